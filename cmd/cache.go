@@ -0,0 +1,240 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"support-workflow/pkg/config"
+	"support-workflow/pkg/utils"
+	"support-workflow/pkg/workflow"
+)
+
+func printJSON(value interface{}) error {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+func failed(err error) int {
+	fmt.Fprintln(os.Stderr, err)
+	return 1
+}
+
+// openCache 只读取缓存配置，不要求配置完整，便于在配置有问题时排查
+func openCache(options config.LoadOptions) (*utils.Cache, error) {
+	conf, err := config.Load(options)
+	if err != nil {
+		return nil, err
+	}
+	return workflow.OpenCache(conf.CacheBackend, conf.CachePath)
+}
+
+const cacheUsage = "cache ls [namespace [prefix]] | get <namespace> <key> | del <namespace> <key> | " +
+	"export [file] | import <file> [--replace] | compact"
+
+func cacheCommand(options config.LoadOptions, args []string) int {
+	if len(args) == 0 {
+		return usageError(cacheUsage)
+	}
+	fs := flag.NewFlagSet("cache "+args[0], flag.ExitOnError)
+	replace := fs.Bool("replace", false, "clear the cache before importing")
+	positional := parseArgs(fs, args[1:])
+
+	if args[0] == "compact" {
+		if len(positional) != 0 {
+			return usageError(cacheUsage)
+		}
+		return compactCache(options)
+	}
+	cache, err := openCache(options)
+	if err != nil {
+		return failed(err)
+	}
+	defer cache.Close()
+	err = runCacheCommand(cache, args[0], positional, *replace)
+	if errors.Is(err, errUsage) {
+		return usageError(cacheUsage)
+	}
+	if err != nil {
+		return failed(err)
+	}
+	return 0
+}
+
+var errUsage = errors.New("usage")
+
+func runCacheCommand(cache *utils.Cache, command string, positional []string, replace bool) error {
+	switch command {
+	case "ls":
+		return listCache(cache, positional)
+	case "get", "del":
+		if len(positional) != 2 {
+			return errUsage
+		}
+		namespace, key := positional[0], utils.ParseKey(positional[1])
+		info, value, err := cache.Lookup(namespace, key)
+		if err != nil {
+			return err
+		}
+		if info == nil {
+			return fmt.Errorf("key %s not found in namespace %s", positional[1], namespace)
+		}
+		if command == "del" {
+			return cache.DeleteEntry(namespace, key)
+		}
+		return printJSON(struct {
+			*utils.KeyInfo
+			Value json.RawMessage `json:"value"`
+		}{info, value})
+	case "export":
+		if len(positional) > 1 {
+			return errUsage
+		}
+		return exportCache(cache, positional)
+	case "import":
+		if len(positional) != 1 {
+			return errUsage
+		}
+		return importCache(cache, positional[0], replace)
+	}
+	return errUsage
+}
+
+func formatExpiry(expiresAt int64) string {
+	if expiresAt == 0 {
+		return "-"
+	}
+	return time.Unix(expiresAt, 0).Format(time.RFC3339)
+}
+
+func listCache(cache *utils.Cache, positional []string) error {
+	out := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	defer out.Flush()
+	switch len(positional) {
+	case 0:
+		namespaces, err := cache.Namespaces()
+		fmt.Fprintln(out, "NAMESPACE\tKEYS")
+		for _, namespace := range namespaces {
+			fmt.Fprintf(out, "%s\t%d\n", namespace.Name, namespace.Keys)
+		}
+		return err
+	case 1, 2:
+		prefix := ""
+		if len(positional) == 2 {
+			prefix = positional[1]
+		}
+		keys, err := cache.Keys(positional[0], prefix)
+		fmt.Fprintln(out, "KEY\tTYPE\tSIZE\tEXPIRES")
+		for _, key := range keys {
+			fmt.Fprintf(out, "%s\t%s\t%d\t%s\n", key.Key, key.Type, key.Size, formatExpiry(key.ExpiresAt))
+		}
+		return err
+	}
+	return errUsage
+}
+
+func exportCache(cache *utils.Cache, positional []string) error {
+	snapshot, err := cache.Export()
+	if err != nil {
+		return err
+	}
+	if len(positional) == 0 {
+		return printJSON(snapshot)
+	}
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.WriteFile(positional[0], data, 0600); err != nil {
+		return err
+	}
+	fmt.Printf("exported %d namespaces to %s\n", len(snapshot.Namespaces), positional[0])
+	return nil
+}
+
+func importCache(cache *utils.Cache, file string, replace bool) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	snapshot := utils.Snapshot{}
+	if err = json.Unmarshal(data, &snapshot); err != nil {
+		return fmt.Errorf("%s: %w", file, err)
+	}
+	imported, err := cache.Import(&snapshot, replace)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("imported %d keys from %s\n", imported, file)
+	return nil
+}
+
+func compactCache(options config.LoadOptions) int {
+	conf, err := config.Load(options)
+	if err != nil {
+		return failed(err)
+	}
+	before, after, err := utils.CompactFile(conf.CacheBackend, conf.CachePath)
+	if err != nil {
+		return failed(err)
+	}
+	fmt.Printf("compacted %s from %d to %d bytes\n", conf.CachePath, before, after)
+	return 0
+}
+
+func checkpointCommand(options config.LoadOptions, args []string) int {
+	if len(args) == 0 {
+		return usageError("checkpoint show [task] | reset <task> [--marker N]")
+	}
+	fs := flag.NewFlagSet("checkpoint "+args[0], flag.ExitOnError)
+	marker := fs.Int("marker", -1, "rewind to this marker instead of resetting")
+	positional := parseArgs(fs, args[1:])
+	if (args[0] != "show" || len(positional) > 1) && (args[0] != "reset" || len(positional) != 1) {
+		return usageError("checkpoint show [task] | reset <task> [--marker N]")
+	}
+	cache, err := openCache(options)
+	if err != nil {
+		return failed(err)
+	}
+	defer cache.Close()
+	switch {
+	case args[0] == "show" && len(positional) == 0:
+		checkpoints, err := workflow.ListCheckpoints(cache)
+		if err != nil {
+			return failed(err)
+		}
+		_ = printJSON(checkpoints)
+		return 0
+	case args[0] == "show" && len(positional) == 1:
+		checkpoint, err := workflow.GetCheckpoint(cache, positional[0])
+		if err != nil {
+			return failed(fmt.Errorf("%s: %w", positional[0], err))
+		}
+		_ = printJSON(checkpoint)
+		return 0
+	case args[0] == "reset" && len(positional) == 1:
+		task := positional[0]
+		if *marker < 0 {
+			if err := workflow.ResetCheckpoint(cache, task); err != nil {
+				return failed(fmt.Errorf("%s: %w", task, err))
+			}
+			fmt.Printf("checkpoint of %s reset, the next sync starts from scratch\n", task)
+			return 0
+		}
+		checkpoint, err := workflow.RewindCheckpoint(cache, task, *marker)
+		if err != nil {
+			return failed(fmt.Errorf("%s: %w", task, err))
+		}
+		_ = printJSON(checkpoint)
+		return 0
+	}
+	return usageError("checkpoint show [task] | reset <task> [--marker N]")
+}
@@ -1,7 +1,213 @@
 package main
 
-import "support-workflow/pkg/workflow"
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"support-workflow/pkg/config"
+	"support-workflow/pkg/utils"
+	"support-workflow/pkg/workflow"
+)
+
+const usage = `Usage: support-workflow [-f config.yml] [--<config-key> value ...] <command> [arguments]
+
+Commands:
+  serve [--dry-run] [--full]             start the HTTP server and scheduled tasks (default)
+  sync <task> [--dry-run] [--full]       run a task once and exit, tasks: %s
+  cache ls [namespace [prefix]]          list namespaces, or keys with type, size and expiry
+  cache get <namespace> <key>            print a decoded entry, sequence keys are written as #N
+  cache del <namespace> <key>            delete an entry
+  cache export [file]                    export all namespaces as JSON
+  cache import <file> [--replace]        import an export, --replace clears the cache first
+  cache compact                          compact the cache file to reclaim space
+  checkpoint show [task]                 show incremental sync markers
+  checkpoint reset <task> [--marker N]   sync the task from scratch, or from marker N
+  config check [--probe] [--timeout d] [--stand-in KEY=ADDRESS]
+  config seal <secrets.json> <secrets.enc>
+  config keygen
+  company create --name <company> --product <product> [--submitter name] [--sale name] [--delivery name]
+
+Global flags:
+`
 
 func main() {
-	workflow.RunForever()
+	configPath := flag.String("f", "config.yml", "config.yml path")
+	// -run、-dry-run、-full 为兼容旧的启动方式保留，等同于 sync 和 serve 的参数
+	runTask := flag.String("run", "", "deprecated, use sync <task>")
+	dryRun := flag.Bool("dry-run", false, "deprecated, use sync <task> --dry-run")
+	fullSync := flag.Bool("full", false, "deprecated, use sync <task> --full")
+	overrides := config.RegisterFlags(flag.CommandLine)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), usage, strings.Join(workflow.TaskNames, ", "))
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	overrides.Collect(flag.CommandLine)
+
+	pathRequired := false
+	flag.Visit(func(f *flag.Flag) {
+		pathRequired = pathRequired || f.Name == "f"
+	})
+	options := config.LoadOptions{Path: *configPath, PathRequired: pathRequired, Overrides: overrides}
+	taskOptions := workflow.TaskOptions{DryRun: *dryRun, FullSync: *fullSync}
+
+	args := flag.Args()
+	if *runTask != "" {
+		args = append([]string{"sync", *runTask}, args...)
+	}
+	if len(args) == 0 {
+		args = []string{"serve"}
+	}
+	os.Exit(dispatch(options, taskOptions, args[0], args[1:]))
+}
+
+func dispatch(options config.LoadOptions, taskOptions workflow.TaskOptions, command string, args []string) int {
+	switch command {
+	case "serve":
+		return serveCommand(options, taskOptions, args)
+	case "sync":
+		return syncCommand(options, taskOptions, args)
+	case "cache":
+		return cacheCommand(options, args)
+	case "checkpoint":
+		return checkpointCommand(options, args)
+	case "config":
+		return configCommand(options, args)
+	case "company":
+		return companyCommand(options, args)
+	case "help":
+		flag.Usage()
+		return 0
+	}
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n", command)
+	flag.Usage()
+	return 2
+}
+
+// parseArgs 解析子命令的参数，参数可以写在位置参数之后，如 sync maintenances --dry-run
+func parseArgs(fs *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		_ = fs.Parse(args)
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// setup 加载并校验配置后打开缓存，缓存在命令结束时关闭
+func setup(options config.LoadOptions) (*workflow.TaskManager, func(), error) {
+	config.Setup(options)
+	conf := config.GetConf()
+	cache, err := workflow.OpenCache(conf.CacheBackend, conf.CachePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	closeCache := func() {
+		if err := cache.Close(); err != nil {
+			log.Printf("Close cache failed: %v", err)
+		}
+	}
+	return workflow.NewTaskManager(cache, utils.NewOutbox(cache), workflow.TaskOptions{}), closeCache, nil
+}
+
+func usageError(format string, args ...interface{}) int {
+	fmt.Fprintf(os.Stderr, "usage: "+format+"\n", args...)
+	return 2
+}
+
+func serveCommand(options config.LoadOptions, taskOptions workflow.TaskOptions, args []string) int {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	fs.BoolVar(&taskOptions.DryRun, "dry-run", taskOptions.DryRun, "compute and report changes without writing to feishu")
+	fs.BoolVar(&taskOptions.FullSync, "full", taskOptions.FullSync, "ignore markers and sync everything")
+	if positional := parseArgs(fs, args); len(positional) > 0 {
+		return usageError("serve [--dry-run] [--full]")
+	}
+	taskManager, closeCache, err := setup(options)
+	if err != nil {
+		return failed(err)
+	}
+	defer closeCache()
+	taskManager.Options = taskOptions
+	workflow.Serve(taskManager)
+	return 0
+}
+
+func syncCommand(options config.LoadOptions, taskOptions workflow.TaskOptions, args []string) int {
+	fs := flag.NewFlagSet("sync", flag.ExitOnError)
+	fs.BoolVar(&taskOptions.DryRun, "dry-run", taskOptions.DryRun, "compute and report changes without writing to feishu")
+	fs.BoolVar(&taskOptions.FullSync, "full", taskOptions.FullSync, "ignore markers and sync everything")
+	positional := parseArgs(fs, args)
+	if len(positional) != 1 {
+		return usageError("sync <%s> [--dry-run] [--full]", strings.Join(workflow.TaskNames, "|"))
+	}
+	taskManager, closeCache, err := setup(options)
+	if err != nil {
+		return failed(err)
+	}
+	defer closeCache()
+	taskManager.Options = taskOptions
+	if err = workflow.RunTaskOnce(taskManager, positional[0]); err != nil {
+		return failed(err)
+	}
+	return 0
+}
+
+func configCommand(options config.LoadOptions, args []string) int {
+	if len(args) == 0 {
+		return usageError("config <check|seal|keygen>")
+	}
+	switch args[0] {
+	case "check":
+		return workflow.CheckConfig(options, args[1:])
+	case "seal":
+		return workflow.SealSecrets(args[1:])
+	case "keygen":
+		key, err := config.GenerateSecretsKey()
+		if err != nil {
+			log.Printf("Generate secrets key failed: %v", err)
+			return 1
+		}
+		fmt.Println(key)
+		return 0
+	}
+	return usageError("config <check|seal|keygen>")
+}
+
+func companyCommand(options config.LoadOptions, args []string) int {
+	if len(args) == 0 || args[0] != "create" {
+		return usageError("company create --name <company> --product <product>")
+	}
+	fs := flag.NewFlagSet("company create", flag.ExitOnError)
+	companyReq := workflow.CompanyRequest{}
+	fs.StringVar(&companyReq.CompanyName, "name", "", "company full name, required")
+	fs.StringVar(&companyReq.ProductName, "product", "", "product name, required")
+	fs.StringVar(&companyReq.Submitter, "submitter", "", "submitter name")
+	fs.StringVar(&companyReq.SaleUser, "sale", "", "sales owner, added to the support chat")
+	fs.StringVar(&companyReq.DeliveryUser, "delivery", "", "delivery owner, added to the support chat")
+	if positional := parseArgs(fs, args[1:]); len(positional) > 0 || companyReq.CompanyName == "" || companyReq.ProductName == "" {
+		return usageError("company create --name <company> --product <product> [--submitter name] [--sale name] [--delivery name]")
+	}
+	taskManager, closeCache, err := setup(options)
+	if err != nil {
+		return failed(err)
+	}
+	defer closeCache()
+	warning, err := taskManager.RegisterCompany(companyReq)
+	if err != nil {
+		return failed(fmt.Errorf("create company %s failed: %w", companyReq.CompanyName, err))
+	}
+	if warning != "" {
+		log.Print(warning)
+	}
+	// 单次执行不启动后台投递，退出前把登记通知发出去
+	taskManager.Outbox.Deliver()
+	fmt.Printf("company %s created\n", companyReq.CompanyName)
+	return 0
 }
@@ -0,0 +1,116 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ProbeResult 一个地址的连通性检查结果
+type ProbeResult struct {
+	Key     string
+	Address string
+	// StandIn 是否使用了替身地址
+	StandIn bool
+	Err     error
+}
+
+// StandIns 检查时替换真实地址的替身，键为配置项名称，如 SUPPORT_ENDPOINT=http://127.0.0.1:8081
+type StandIns map[string]string
+
+func (s StandIns) String() string {
+	pairs := make([]string, 0, len(s))
+	for key, value := range s {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// Set 实现 flag.Value，可以多次指定 --stand-in KEY=URL
+func (s StandIns) Set(value string) error {
+	key, target, found := strings.Cut(value, "=")
+	if !found || key == "" || target == "" {
+		return fmt.Errorf("stand-in %q should be KEY=ADDRESS", value)
+	}
+	s[strings.ToUpper(key)] = target
+	return nil
+}
+
+// probeTargets 需要连通的外部地址，键为配置项名称
+func (c *Config) probeTargets() map[string]string {
+	targets := map[string]string{
+		"SUPPORT_ENDPOINT":             c.SupportEndpoint,
+		"FEISHU_ENDPOINT":              c.FeishuEndpoint,
+		"WECHAT_GROUP_ROBOT_WEBHOOK":   c.WechatGroupRobotWebhook.Reveal(),
+		"WECHAT_MESSAGE_ROBOT_WEBHOOK": c.WechatMessageRobotWebhook.Reveal(),
+		"REMINDER_WEBHOOK":             c.ReminderWebhook.Reveal(),
+	}
+	for i, nc := range c.Notifiers {
+		key := fmt.Sprintf("NOTIFIERS[%d]", i)
+		if nc.Type == "email" {
+			targets[key+".SMTP_HOST"] = fmt.Sprintf("%s:%d", nc.SMTPHost, nc.SMTPPort)
+			continue
+		}
+		targets[key+".URL"] = nc.URL.Reveal()
+	}
+	for key, target := range targets {
+		if target == "" {
+			delete(targets, key)
+		}
+	}
+	return targets
+}
+
+// dialAddress 把 URL 转为 host:port，没有协议的地址视为 host:port
+func dialAddress(target string) (string, error) {
+	if !strings.Contains(target, "://") {
+		return target, nil
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", err
+	}
+	if u.Port() != "" {
+		return u.Host, nil
+	}
+	if u.Scheme == "https" {
+		return net.JoinHostPort(u.Hostname(), "443"), nil
+	}
+	return net.JoinHostPort(u.Hostname(), "80"), nil
+}
+
+// Probe 对配置中的外部地址做 TCP 连接检查，不发送请求也不使用任何凭证；
+// 替身中的地址优先，便于在隔离环境中对着模拟服务检查
+func (c *Config) Probe(standIns StandIns, timeout time.Duration) []ProbeResult {
+	targets := c.probeTargets()
+	for key, target := range standIns {
+		targets[key] = target
+	}
+	keys := make([]string, 0, len(targets))
+	for key := range targets {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	results := make([]ProbeResult, 0, len(keys))
+	for _, key := range keys {
+		_, standIn := standIns[key]
+		// 只展示 host:port，webhook 地址中的 key 属于凭证
+		result := ProbeResult{Key: key, StandIn: standIn}
+		address, err := dialAddress(targets[key])
+		result.Address = address
+		if err == nil {
+			var conn net.Conn
+			if conn, err = net.DialTimeout("tcp", address, timeout); err == nil {
+				_ = conn.Close()
+			}
+		}
+		result.Err = err
+		results = append(results, result)
+	}
+	return results
+}
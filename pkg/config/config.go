@@ -1,29 +1,82 @@
 package config
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"os"
 
 	"github.com/spf13/viper"
 )
 
+// NotifierConfig 一个通知渠道，type 为 wecom、feishu、dingtalk、webhook、email
+type NotifierConfig struct {
+	Name         string   `mapstructure:"NAME"`
+	Type         string   `mapstructure:"TYPE"`
+	URL          Secret   `mapstructure:"URL"`
+	Secret       Secret   `mapstructure:"SECRET"`
+	SMTPHost     string   `mapstructure:"SMTP_HOST"`
+	SMTPPort     int      `mapstructure:"SMTP_PORT"`
+	SMTPUsername string   `mapstructure:"SMTP_USERNAME"`
+	SMTPPassword Secret   `mapstructure:"SMTP_PASSWORD"`
+	SMTPFrom     string   `mapstructure:"SMTP_FROM"`
+	SMTPTo       []string `mapstructure:"SMTP_TO"`
+}
+
 type Config struct {
 	Port                      string `mapstructure:"PORT"`
-	WechatGroupRobotWebhook   string `mapstructure:"WECHAT_GROUP_ROBOT_WEBHOOK"`
-	WechatMessageRobotWebhook string `mapstructure:"WECHAT_MESSAGE_ROBOT_WEBHOOK"`
+	WechatGroupRobotWebhook   Secret `mapstructure:"WECHAT_GROUP_ROBOT_WEBHOOK"`
+	WechatMessageRobotWebhook Secret `mapstructure:"WECHAT_MESSAGE_ROBOT_WEBHOOK"`
 	RobotRemindsMobilePhones  string `mapstructure:"ROBOT_REMINDS_MOBILE_PHONES"`
 	SupportEndpoint           string `mapstructure:"SUPPORT_ENDPOINT"`
 	SupportUsername           string `mapstructure:"SUPPORT_USERNAME"`
-	SupportPassword           string `mapstructure:"SUPPORT_PASSWORD"`
+	SupportPassword           Secret `mapstructure:"SUPPORT_PASSWORD"`
 	FeishuEndpoint            string `mapstructure:"FEISHU_ENDPOINT"`
 	FeishuAppID               string `mapstructure:"FEISHU_APP_ID"`
-	FeishuAppSecret           string `mapstructure:"FEISHU_APP_SECRET"`
+	FeishuAppSecret           Secret `mapstructure:"FEISHU_APP_SECRET"`
 	FeishuTableAppToken       string `mapstructure:"FEISHU_TABLE_APP_TOKEN"`
 	FeishuTableID             string `mapstructure:"FEISHU_TABLE_ID"`
+	FeishuTableURL            string `mapstructure:"FEISHU_TABLE_URL"`
+	FeishuChangeLogTableID    string `mapstructure:"FEISHU_CHANGELOG_TABLE_ID"`
+	FeishuArchiveTableID      string `mapstructure:"FEISHU_ARCHIVE_TABLE_ID"`
+	MissingCustomerPolicy     string `mapstructure:"MISSING_CUSTOMER_POLICY"`
+	MaintenanceFullSyncTime   string `mapstructure:"MAINTENANCE_FULL_SYNC_TIME"`
+	SyncDigestInterval        string `mapstructure:"SYNC_DIGEST_INTERVAL"`
+	// 飞书字段被人工修改后的处理策略：source-wins、feishu-wins、flag-for-review
+	DefaultConflictPolicy string            `mapstructure:"DEFAULT_CONFLICT_POLICY"`
+	FieldConflictPolicies map[string]string `mapstructure:"FIELD_CONFLICT_POLICIES"`
+	// 到期提醒：提前天数档位、姓名到手机号的映射（用于 @）、提醒使用的机器人
+	ReminderDays     []int             `mapstructure:"REMINDER_DAYS"`
+	ReminderContacts map[string]string `mapstructure:"REMINDER_CONTACTS"`
+	ReminderWebhook  Secret            `mapstructure:"REMINDER_WEBHOOK"`
+	// 任务告警：连续失败次数、无成功执行的时长、相同告警的重复间隔，达到升级条件后 @ ROBOT_REMINDS_MOBILE_PHONES
+	AlertFailureThreshold   int    `mapstructure:"ALERT_FAILURE_THRESHOLD"`
+	AlertStaleAfter         string `mapstructure:"ALERT_STALE_AFTER"`
+	AlertRepeatInterval     string `mapstructure:"ALERT_REPEAT_INTERVAL"`
+	AlertEscalationFailures int    `mapstructure:"ALERT_ESCALATION_FAILURES"`
+	AlertEscalateAfter      string `mapstructure:"ALERT_ESCALATE_AFTER"`
+	// 新客户登记时自动创建支持群：渠道（feishu、wecom）、固定成员手机号，企业微信建群需要自建应用的凭证
+	SupportChatProviders []string `mapstructure:"SUPPORT_CHAT_PROVIDERS"`
+	SupportChatMembers   []string `mapstructure:"SUPPORT_CHAT_MEMBERS"`
+	WecomCorpID          string   `mapstructure:"WECOM_CORP_ID"`
+	WecomCorpSecret      Secret   `mapstructure:"WECOM_CORP_SECRET"`
+	// 任务执行间隔，键为任务名称，如 maintenances: 5m，未配置的任务使用内置间隔
+	TaskIntervals map[string]string `mapstructure:"TASK_INTERVALS"`
+	// 加密的密钥文件，由 config seal 生成，解密密钥来自环境变量 SW_SECRETS_KEY
+	SecretsFile string `mapstructure:"SECRETS_FILE"`
+	// 发件箱单条消息的最大投递次数，超过后转入死信
+	OutboxMaxAttempts int `mapstructure:"OUTBOX_MAX_ATTEMPTS"`
+	// 本地状态存储（marker、变更记录、发件箱等）：bolt、sqlite 或 memory，启动时打开，修改后需要重启
+	CacheBackend string `mapstructure:"CACHE_BACKEND"`
+	// bolt、sqlite 的数据文件，memory 不使用
+	CachePath string `mapstructure:"CACHE_PATH"`
+	// 后台清理缓存中过期键的间隔
+	CacheSweepInterval string `mapstructure:"CACHE_SWEEP_INTERVAL"`
+	// 通知渠道及事件（onboarding、sync、reminder、alert）到渠道名称的路由，未配置时使用上面的企业微信机器人
+	Notifiers    []NotifierConfig    `mapstructure:"NOTIFIERS"`
+	NotifyRoutes map[string][]string `mapstructure:"NOTIFY_ROUTES"`
 }
 
-var GlobalConfig *Config
-
 func getDefaultConfig() Config {
 	return Config{
 		Port:                     "8080",
@@ -37,37 +90,144 @@ func getDefaultConfig() Config {
 		FeishuAppSecret:          "",
 		FeishuTableID:            "",
 		FeishuTableAppToken:      "",
+		FeishuTableURL:           "",
+		FeishuChangeLogTableID:   "",
+		FeishuArchiveTableID:     "",
+		MissingCustomerPolicy:    "report",
+		MaintenanceFullSyncTime:  "02:00",
+		SyncDigestInterval:       "0",
+		DefaultConflictPolicy:    "source-wins",
+		FieldConflictPolicies:    map[string]string{},
+		ReminderDays:             []int{90, 60, 30, 7},
+		ReminderContacts:         map[string]string{},
+		ReminderWebhook:          "",
+		AlertFailureThreshold:    3,
+		AlertStaleAfter:          "30m",
+		AlertRepeatInterval:      "6h",
+		AlertEscalationFailures:  10,
+		AlertEscalateAfter:       "2h",
+		SupportChatProviders:     []string{},
+		SupportChatMembers:       []string{},
+		WecomCorpID:              "",
+		WecomCorpSecret:          "",
+		TaskIntervals:            map[string]string{},
+		SecretsFile:              "",
+		OutboxMaxAttempts:        8,
+		CacheBackend:             "bolt",
+		CachePath:                "cache.db",
+		CacheSweepInterval:       "10m",
+		Notifiers:                []NotifierConfig{},
+		NotifyRoutes:             map[string][]string{},
 	}
 }
 
-func loadConfigFromFile(path string, conf *Config) {
-	var err error
-	_, err = os.Stat(path)
-	if err == nil {
-		fileViper := viper.New()
-		fileViper.SetConfigFile(path)
-		if err = fileViper.ReadInConfig(); err == nil {
-			if err = fileViper.Unmarshal(conf); err == nil {
-				log.Printf("Load config from %s success\n", path)
-				return
-			}
+// EffectiveNotifiers 配置的通知渠道加上由旧版企业微信机器人配置生成的内置渠道
+func (c *Config) EffectiveNotifiers() []NotifierConfig {
+	notifiers := append([]NotifierConfig(nil), c.Notifiers...)
+	builtins := []NotifierConfig{
+		{Name: "wecom-group", Type: "wecom", URL: c.WechatGroupRobotWebhook},
+		{Name: "wecom-message", Type: "wecom", URL: c.WechatMessageRobotWebhook},
+		{Name: "wecom-reminder", Type: "wecom", URL: c.ReminderWebhook},
+	}
+	for _, builtin := range builtins {
+		if builtin.URL != "" {
+			notifiers = append(notifiers, builtin)
 		}
 	}
-	if err != nil {
-		log.Fatalf("Load config from %s failed: %s\n", path, err)
+	return notifiers
+}
+
+// EffectiveNotifyRoutes 未在 NOTIFY_ROUTES 中配置的事件沿用旧版机器人的用途
+func (c *Config) EffectiveNotifyRoutes() map[string][]string {
+	routes := make(map[string][]string)
+	if c.WechatGroupRobotWebhook != "" {
+		routes["onboarding"] = []string{"wecom-group"}
+		routes["reminder"] = []string{"wecom-group"}
+	}
+	if c.WechatMessageRobotWebhook != "" {
+		routes["sync"] = []string{"wecom-message"}
+		routes["alert"] = []string{"wecom-message"}
+	}
+	if c.ReminderWebhook != "" {
+		routes["reminder"] = []string{"wecom-reminder"}
+	}
+	for event, names := range c.NotifyRoutes {
+		routes[event] = names
+	}
+	return routes
+}
+
+// LoadOptions 配置来源，优先级从高到低：命令行参数 > SW_ 环境变量（含 SW_<KEY>_FILE） > 加密密钥文件 > 配置文件 > 默认值
+type LoadOptions struct {
+	Path string
+	// PathRequired 显式指定了配置文件时文件必须存在，否则缺少文件时只使用环境变量和默认值
+	PathRequired bool
+	Overrides    Overrides
+}
+
+func loadConfigFromFile(path string, conf *Config) error {
+	fileViper := viper.New()
+	fileViper.SetConfigFile(path)
+	if err := fileViper.ReadInConfig(); err != nil {
+		return err
 	}
+	return fileViper.Unmarshal(conf)
 }
 
-func Setup(configPath string) {
+func Load(options LoadOptions) (*Config, error) {
 	var conf = getDefaultConfig()
-	loadConfigFromFile(configPath, &conf)
-	GlobalConfig = &conf
-	log.Printf("%+v\n", GlobalConfig)
+	if _, err := os.Stat(options.Path); err == nil {
+		if err = loadConfigFromFile(options.Path, &conf); err != nil {
+			return nil, fmt.Errorf("load config from %s failed: %w", options.Path, err)
+		}
+		log.Printf("Load config from %s success\n", options.Path)
+	} else if options.PathRequired || !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("load config from %s failed: %w", options.Path, err)
+	} else {
+		log.Printf("Config file %s not found, use env and defaults\n", options.Path)
+	}
+	// 密钥文件的路径本身可以由环境变量或命令行指定
+	if path, exists := os.LookupEnv(EnvPrefix + "SECRETS_FILE"); exists {
+		conf.SecretsFile = path
+	}
+	if path, exists := options.Overrides["SECRETS_FILE"]; exists {
+		conf.SecretsFile = path
+	}
+	if err := applySecretsFile(&conf); err != nil {
+		return nil, err
+	}
+	if err := applyEnv(&conf); err != nil {
+		return nil, err
+	}
+	if err := applySecretFiles(&conf); err != nil {
+		return nil, err
+	}
+	if err := applyOverrides(&conf, options.Overrides); err != nil {
+		return nil, err
+	}
+	return &conf, nil
+}
+
+func Setup(options LoadOptions) {
+	log.SetOutput(LogWriter)
+	conf, err := Load(options)
+	if err != nil {
+		log.Fatalf("%v\n", err)
+	}
+	// 校验错误中可能带有敏感值，先注册再输出
+	LogWriter.SetSecrets(conf.SecretValues())
+	if err = conf.Validate(); err != nil {
+		log.Fatalf("%v\n", err)
+	}
+	loadOptions = options
+	current.Store(conf)
+	log.Printf("%+v\n", conf)
 }
 
 func GetConf() Config {
-	if GlobalConfig == nil {
+	conf := current.Load()
+	if conf == nil {
 		return getDefaultConfig()
 	}
-	return *GlobalConfig
+	return *conf
 }
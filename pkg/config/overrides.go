@@ -0,0 +1,163 @@
+package config
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/go-viper/mapstructure/v2"
+)
+
+// EnvPrefix 环境变量前缀，例如 SW_FEISHU_APP_SECRET 覆盖 FEISHU_APP_SECRET
+const EnvPrefix = "SW_"
+
+// Overrides 命令行中显式指定的配置项，键为配置文件中的名称
+type Overrides map[string]string
+
+// configKeys 返回 Config 所有字段在配置文件中的名称及对应的字段序号
+func configKeys() map[string]int {
+	keys := make(map[string]int)
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		if key := t.Field(i).Tag.Get("mapstructure"); key != "" {
+			keys[key] = i
+		}
+	}
+	return keys
+}
+
+func sortedConfigKeys() []string {
+	keys := make([]string, 0)
+	for key := range configKeys() {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// FlagName 配置项对应的命令行参数名，例如 FEISHU_APP_SECRET 对应 --feishu-app-secret
+func FlagName(key string) string {
+	return strings.ReplaceAll(strings.ToLower(key), "_", "-")
+}
+
+// RegisterFlags 为每个配置项注册命令行参数，解析后调用 Overrides.Collect 取出显式指定的值
+func RegisterFlags(fs *flag.FlagSet) Overrides {
+	overrides := make(Overrides)
+	t := reflect.TypeOf(Config{})
+	keys := configKeys()
+	for _, key := range sortedConfigKeys() {
+		field := t.Field(keys[key])
+		usage := fmt.Sprintf("override %s (env %s%s)", key, EnvPrefix, key)
+		if isComplexKind(field.Type.Kind()) {
+			usage += ", JSON value"
+		}
+		fs.String(FlagName(key), "", usage)
+	}
+	return overrides
+}
+
+// Collect 只收集命令行中出现过的参数，未指定的参数不覆盖低优先级的配置
+func (o Overrides) Collect(fs *flag.FlagSet) {
+	names := make(map[string]string)
+	for key := range configKeys() {
+		names[FlagName(key)] = key
+	}
+	fs.Visit(func(f *flag.Flag) {
+		if key, exists := names[f.Name]; exists {
+			o[key] = f.Value.String()
+		}
+	})
+}
+
+func isComplexKind(kind reflect.Kind) bool {
+	return kind == reflect.Slice || kind == reflect.Map || kind == reflect.Struct
+}
+
+// applyValue 把字符串形式的值写入配置字段：简单类型直接转换，
+// 列表可用 JSON 或逗号分隔，映射和结构体列表使用 JSON，键名与配置文件一致
+func applyValue(conf *Config, key, raw string) error {
+	index, exists := configKeys()[key]
+	if !exists {
+		return fmt.Errorf("unknown config key %s", key)
+	}
+	field := reflect.ValueOf(conf).Elem().Field(index)
+	var input interface{} = raw
+	if isComplexKind(field.Kind()) {
+		trimmed := strings.TrimSpace(raw)
+		switch {
+		case trimmed == "":
+			input = nil
+		case strings.HasPrefix(trimmed, "[") || strings.HasPrefix(trimmed, "{"):
+			if err := json.Unmarshal([]byte(trimmed), &input); err != nil {
+				return fmt.Errorf("%s: invalid JSON: %w", key, err)
+			}
+		case field.Kind() == reflect.Slice:
+			input = strings.Split(trimmed, ",")
+		default:
+			return fmt.Errorf("%s: expect a JSON value", key)
+		}
+	}
+	// 覆盖而不是合并，避免默认值中的列表元素残留
+	field.Set(reflect.Zero(field.Type()))
+	if input == nil {
+		return nil
+	}
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		WeaklyTypedInput: true,
+		TagName:          "mapstructure",
+		Result:           field.Addr().Interface(),
+	})
+	if err != nil {
+		return err
+	}
+	if err = decoder.Decode(input); err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	lowerMapKeys(field)
+	return nil
+}
+
+// lowerMapKeys viper 读取配置文件时会把映射的键转为小写，环境变量和命令行保持一致
+func lowerMapKeys(field reflect.Value) {
+	if field.Kind() != reflect.Map || field.Type().Key().Kind() != reflect.String || field.IsNil() {
+		return
+	}
+	lowered := reflect.MakeMap(field.Type())
+	iter := field.MapRange()
+	for iter.Next() {
+		lowered.SetMapIndex(reflect.ValueOf(strings.ToLower(iter.Key().String())).Convert(field.Type().Key()), iter.Value())
+	}
+	field.Set(lowered)
+}
+
+// applyEnv 读取 SW_ 前缀的环境变量
+func applyEnv(conf *Config) error {
+	for _, key := range sortedConfigKeys() {
+		raw, exists := os.LookupEnv(EnvPrefix + key)
+		if !exists {
+			continue
+		}
+		if err := applyValue(conf, key, raw); err != nil {
+			return fmt.Errorf("env %s%s: %w", EnvPrefix, key, err)
+		}
+	}
+	return nil
+}
+
+func applyOverrides(conf *Config, overrides Overrides) error {
+	keys := make([]string, 0, len(overrides))
+	for key := range overrides {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := applyValue(conf, key, overrides[key]); err != nil {
+			return fmt.Errorf("flag --%s: %w", FlagName(key), err)
+		}
+	}
+	return nil
+}
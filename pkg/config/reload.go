@@ -0,0 +1,88 @@
+package config
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+)
+
+var (
+	// current 当前生效的配置，重新加载时整体替换，读取方通过 GetConf 拿到一份副本
+	current     atomic.Pointer[Config]
+	loadOptions LoadOptions
+
+	reloadMu    sync.Mutex
+	listenersMu sync.Mutex
+	listeners   []func(old, new Config)
+)
+
+// OnChange 注册配置变更回调，只有重新加载成功且内容变化时才会调用
+func OnChange(fn func(old, new Config)) {
+	listenersMu.Lock()
+	defer listenersMu.Unlock()
+	listeners = append(listeners, fn)
+}
+
+// Reload 按启动时的来源重新加载配置，校验失败时保留原配置
+func Reload() error {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	conf, err := Load(loadOptions)
+	if err != nil {
+		return fmt.Errorf("reload config failed, keep the current one: %w", err)
+	}
+	// 新旧配置的敏感值都需要掩盖：校验错误中可能带有新值，正在执行的任务仍可能输出旧值
+	old := GetConf()
+	LogWriter.SetSecrets(append(old.SecretValues(), conf.SecretValues()...))
+	if err = conf.Validate(); err != nil {
+		return fmt.Errorf("reload config failed, keep the current one: %w", err)
+	}
+	if reflect.DeepEqual(old, *conf) {
+		return nil
+	}
+	current.Store(conf)
+	log.Printf("Config reloaded: %+v\n", conf)
+
+	listenersMu.Lock()
+	callbacks := append([]func(old, new Config){}, listeners...)
+	listenersMu.Unlock()
+	for _, fn := range callbacks {
+		fn(old, *conf)
+	}
+	return nil
+}
+
+func reload(reason string) {
+	log.Printf("Reload config: %s", reason)
+	if err := Reload(); err != nil {
+		log.Print(err)
+	}
+}
+
+// Watch 配置文件变化或收到 SIGHUP 时重新加载；没有配置文件时只响应 SIGHUP
+func Watch() {
+	if _, err := os.Stat(loadOptions.Path); err == nil {
+		fileViper := viper.New()
+		fileViper.SetConfigFile(loadOptions.Path)
+		fileViper.OnConfigChange(func(e fsnotify.Event) {
+			reload(fmt.Sprintf("%s %s", e.Name, e.Op))
+		})
+		fileViper.WatchConfig()
+	}
+
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	go func() {
+		for range hangup {
+			reload("SIGHUP")
+		}
+	}()
+}
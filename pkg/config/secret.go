@@ -0,0 +1,249 @@
+package config
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	redacted = "******"
+
+	// SecretsKeyEnv 加密密钥文件的密钥，base64 编码的 32 字节
+	SecretsKeyEnv = EnvPrefix + "SECRETS_KEY"
+	// fileSuffix 环境变量 SW_<KEY>_FILE 指向的文件内容作为 <KEY> 的值，适用于容器挂载的 secret
+	fileSuffix = "_FILE"
+)
+
+// Secret 密码、密钥、带 key 的 webhook 等敏感配置，格式化和序列化时只输出掩码，使用时调用 Reveal
+type Secret string
+
+func (s Secret) Reveal() string {
+	return string(s)
+}
+
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return redacted
+}
+
+func (s Secret) GoString() string {
+	return fmt.Sprintf("%q", s.String())
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+var secretType = reflect.TypeOf(Secret(""))
+
+// secretKeys Config 中 Secret 类型字段的配置项名称
+func secretKeys() []string {
+	var keys []string
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Type == secretType {
+			keys = append(keys, t.Field(i).Tag.Get("mapstructure"))
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// applySecretFiles 读取 SW_<KEY>_FILE 指向的文件，文件末尾的换行会被去掉
+func applySecretFiles(conf *Config) error {
+	for _, key := range secretKeys() {
+		path, exists := os.LookupEnv(EnvPrefix + key + fileSuffix)
+		if !exists {
+			continue
+		}
+		if _, both := os.LookupEnv(EnvPrefix + key); both {
+			return fmt.Errorf("env %s%s and %s%s%s are both set", EnvPrefix, key, EnvPrefix, key, fileSuffix)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("env %s%s%s: %w", EnvPrefix, key, fileSuffix, err)
+		}
+		if err = applyValue(conf, key, strings.TrimRight(string(data), "\r\n")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func secretsCipher() (cipher.AEAD, error) {
+	encoded := os.Getenv(SecretsKeyEnv)
+	if encoded == "" {
+		return nil, fmt.Errorf("env %s is required to use SECRETS_FILE", SecretsKeyEnv)
+	}
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("env %s should be a base64 encoded 32 bytes key", SecretsKeyEnv)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// GenerateSecretsKey 生成用于 SW_SECRETS_KEY 的随机密钥
+func GenerateSecretsKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// SealSecrets 用 SW_SECRETS_KEY 加密 JSON 格式的密钥文件（配置项名称到值的映射），
+// 输出为 base64(nonce + AES-GCM 密文)
+func SealSecrets(plaintext []byte) ([]byte, error) {
+	var values map[string]interface{}
+	if err := json.Unmarshal(plaintext, &values); err != nil {
+		return nil, fmt.Errorf("secrets should be a JSON object: %w", err)
+	}
+	aead, err := secretsCipher()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := aead.Seal(nonce, nonce, plaintext, nil)
+	return []byte(base64.StdEncoding.EncodeToString(sealed) + "\n"), nil
+}
+
+func openSecrets(data []byte) (map[string]interface{}, error) {
+	aead, err := secretsCipher()
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(data)))
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("secrets file is not sealed by config seal")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt secrets file failed, check %s: %w", SecretsKeyEnv, err)
+	}
+	var values map[string]interface{}
+	if err = json.Unmarshal(plaintext, &values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// applySecretsFile 解密 SECRETS_FILE 并覆盖配置文件中的值，优先级低于环境变量
+func applySecretsFile(conf *Config) error {
+	if conf.SecretsFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(conf.SecretsFile)
+	if err != nil {
+		return fmt.Errorf("SECRETS_FILE: %w", err)
+	}
+	values, err := openSecrets(data)
+	if err != nil {
+		return fmt.Errorf("SECRETS_FILE %s: %w", conf.SecretsFile, err)
+	}
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		raw, ok := values[key].(string)
+		if !ok {
+			encoded, _ := json.Marshal(values[key])
+			raw = string(encoded)
+		}
+		if err = applyValue(conf, key, raw); err != nil {
+			return fmt.Errorf("SECRETS_FILE %s: %w", conf.SecretsFile, err)
+		}
+	}
+	return nil
+}
+
+// SecretValues 配置中所有非空的敏感值，包括通知渠道中的 secret
+func (c *Config) SecretValues() []string {
+	var values []string
+	add := func(secret Secret) {
+		if secret != "" {
+			values = append(values, secret.Reveal())
+		}
+	}
+	v := reflect.ValueOf(*c)
+	for i := 0; i < v.NumField(); i++ {
+		if secret, ok := v.Field(i).Interface().(Secret); ok {
+			add(secret)
+		}
+	}
+	for _, nc := range c.Notifiers {
+		add(nc.URL)
+		add(nc.Secret)
+		add(nc.SMTPPassword)
+	}
+	return values
+}
+
+// RedactingWriter 把写入内容中出现的敏感值替换为掩码，用于日志输出
+type RedactingWriter struct {
+	out      io.Writer
+	mu       sync.RWMutex
+	replacer *strings.Replacer
+}
+
+func NewRedactingWriter(out io.Writer) *RedactingWriter {
+	return &RedactingWriter{out: out}
+}
+
+// SetSecrets 更新需要掩盖的值，过短的值容易误伤普通文本，不做替换
+func (w *RedactingWriter) SetSecrets(values []string) {
+	// 长的值优先替换，避免其中包含的短值先被替换后长值无法匹配
+	sorted := append([]string(nil), values...)
+	sort.Slice(sorted, func(i, j int) bool { return len(sorted[i]) > len(sorted[j]) })
+	var pairs []string
+	for _, value := range sorted {
+		if len(value) < 4 {
+			continue
+		}
+		pairs = append(pairs, value, redacted)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.replacer = nil
+	if len(pairs) > 0 {
+		w.replacer = strings.NewReplacer(pairs...)
+	}
+}
+
+func (w *RedactingWriter) Write(p []byte) (int, error) {
+	w.mu.RLock()
+	replacer := w.replacer
+	w.mu.RUnlock()
+	if replacer == nil {
+		return w.out.Write(p)
+	}
+	if _, err := io.WriteString(w.out, replacer.Replace(string(p))); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// LogWriter 标准日志及 HTTP 服务日志统一经过该 writer 输出
+var LogWriter = NewRedactingWriter(os.Stderr)
@@ -0,0 +1,241 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	missingCustomerPolicies = []string{"report", "inactive", "status", "archive"}
+	conflictPolicies        = []string{"source-wins", "feishu-wins", "flag-for-review"}
+	notifierTypes           = []string{"wecom", "feishu", "dingtalk", "webhook", "email"}
+	notifyEvents            = []string{"onboarding", "sync", "reminder", "alert"}
+	cacheBackends           = []string{"bolt", "sqlite", "memory"}
+	supportChatProviders    = []string{"feishu", "wecom"}
+)
+
+// ValidationError 一个配置项的问题及修改建议
+type ValidationError struct {
+	Key     string
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Key, e.Message)
+}
+
+// ValidationErrors 一次校验发现的全部问题
+type ValidationErrors []ValidationError
+
+func (errs ValidationErrors) Error() string {
+	lines := make([]string, 0, len(errs)+1)
+	lines = append(lines, fmt.Sprintf("%d config problems found:", len(errs)))
+	for _, err := range errs {
+		lines = append(lines, "  - "+err.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+type validator struct {
+	errs ValidationErrors
+}
+
+func (v *validator) add(key, format string, args ...interface{}) {
+	v.errs = append(v.errs, ValidationError{Key: key, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) required(key, value string) {
+	if strings.TrimSpace(value) == "" {
+		v.add(key, "is required, set it in the config file or env %s%s", EnvPrefix, key)
+	}
+}
+
+func validURL(value string) bool {
+	u, err := url.Parse(value)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// url 校验 http(s) 地址，required 为 false 时允许为空
+func (v *validator) url(key, value string, required bool) {
+	if value == "" {
+		if required {
+			v.required(key, value)
+		}
+		return
+	}
+	if !validURL(value) {
+		v.add(key, "%q is not a valid http(s) URL", value)
+	}
+}
+
+// secretURL 同 url，带 key 的 webhook 属于凭证，错误信息中不输出原值
+func (v *validator) secretURL(key string, value Secret, required bool) {
+	if value == "" {
+		if required {
+			v.required(key, "")
+		}
+		return
+	}
+	if !validURL(value.Reveal()) {
+		v.add(key, "is not a valid http(s) URL")
+	}
+}
+
+func (v *validator) oneOf(key, value string, allowed []string) {
+	for _, item := range allowed {
+		if value == item {
+			return
+		}
+	}
+	v.add(key, "%q is invalid, expect one of %s", value, strings.Join(allowed, ", "))
+}
+
+func (v *validator) duration(key, value string, allowZero bool) {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		v.add(key, "%q is not a duration, use values like 30m, 6h", value)
+		return
+	}
+	if d < 0 || (d == 0 && !allowZero) {
+		v.add(key, "%q must be positive", value)
+	}
+}
+
+func (v *validator) port(key string, value int) {
+	if value < 1 || value > 65535 {
+		v.add(key, "%d is out of range 1-65535", value)
+	}
+}
+
+// Validate 检查必填项、地址格式、端口范围以及相互依赖的配置，一次返回全部问题
+func (c *Config) Validate() error {
+	v := &validator{}
+
+	port, err := strconv.Atoi(c.Port)
+	if err != nil {
+		v.add("PORT", "%q is not a number", c.Port)
+	} else {
+		v.port("PORT", port)
+	}
+
+	v.url("SUPPORT_ENDPOINT", c.SupportEndpoint, true)
+	v.required("SUPPORT_USERNAME", c.SupportUsername)
+	v.required("SUPPORT_PASSWORD", c.SupportPassword.Reveal())
+	v.url("FEISHU_ENDPOINT", c.FeishuEndpoint, false)
+	v.required("FEISHU_APP_ID", c.FeishuAppID)
+	v.required("FEISHU_APP_SECRET", c.FeishuAppSecret.Reveal())
+	v.required("FEISHU_TABLE_APP_TOKEN", c.FeishuTableAppToken)
+	v.required("FEISHU_TABLE_ID", c.FeishuTableID)
+	v.url("FEISHU_TABLE_URL", c.FeishuTableURL, false)
+	v.secretURL("WECHAT_GROUP_ROBOT_WEBHOOK", c.WechatGroupRobotWebhook, false)
+	v.secretURL("WECHAT_MESSAGE_ROBOT_WEBHOOK", c.WechatMessageRobotWebhook, false)
+	v.secretURL("REMINDER_WEBHOOK", c.ReminderWebhook, false)
+
+	v.oneOf("MISSING_CUSTOMER_POLICY", c.MissingCustomerPolicy, missingCustomerPolicies)
+	if c.MissingCustomerPolicy == "archive" && c.FeishuArchiveTableID == "" {
+		v.add("FEISHU_ARCHIVE_TABLE_ID", "is required when MISSING_CUSTOMER_POLICY is archive")
+	}
+	if _, err = time.Parse("15:04", c.MaintenanceFullSyncTime); err != nil {
+		v.add("MAINTENANCE_FULL_SYNC_TIME", "%q is invalid, use HH:MM such as 02:00", c.MaintenanceFullSyncTime)
+	}
+	v.duration("SYNC_DIGEST_INTERVAL", c.SyncDigestInterval, true)
+
+	v.oneOf("DEFAULT_CONFLICT_POLICY", c.DefaultConflictPolicy, conflictPolicies)
+	for _, field := range sortedMapKeys(c.FieldConflictPolicies) {
+		v.oneOf("FIELD_CONFLICT_POLICIES."+field, c.FieldConflictPolicies[field], conflictPolicies)
+	}
+
+	for _, days := range c.ReminderDays {
+		if days <= 0 {
+			v.add("REMINDER_DAYS", "%d must be positive", days)
+		}
+	}
+
+	if c.AlertFailureThreshold < 1 {
+		v.add("ALERT_FAILURE_THRESHOLD", "%d must be at least 1", c.AlertFailureThreshold)
+	}
+	if c.AlertEscalationFailures < c.AlertFailureThreshold {
+		v.add("ALERT_ESCALATION_FAILURES", "%d must not be less than ALERT_FAILURE_THRESHOLD %d",
+			c.AlertEscalationFailures, c.AlertFailureThreshold)
+	}
+	v.duration("ALERT_STALE_AFTER", c.AlertStaleAfter, false)
+	v.duration("ALERT_REPEAT_INTERVAL", c.AlertRepeatInterval, false)
+	v.duration("ALERT_ESCALATE_AFTER", c.AlertEscalateAfter, false)
+	for _, task := range sortedMapKeys(c.TaskIntervals) {
+		v.duration("TASK_INTERVALS."+task, c.TaskIntervals[task], false)
+	}
+	v.oneOf("CACHE_BACKEND", c.CacheBackend, cacheBackends)
+	if c.CacheBackend != "memory" {
+		v.required("CACHE_PATH", c.CachePath)
+	}
+	v.duration("CACHE_SWEEP_INTERVAL", c.CacheSweepInterval, false)
+	if c.OutboxMaxAttempts < 1 {
+		v.add("OUTBOX_MAX_ATTEMPTS", "%d must be at least 1", c.OutboxMaxAttempts)
+	}
+
+	for _, provider := range c.SupportChatProviders {
+		v.oneOf("SUPPORT_CHAT_PROVIDERS", provider, supportChatProviders)
+		if provider == "wecom" {
+			v.required("WECOM_CORP_ID", c.WecomCorpID)
+			v.required("WECOM_CORP_SECRET", c.WecomCorpSecret.Reveal())
+		}
+	}
+
+	c.validateNotifiers(v)
+
+	if len(v.errs) > 0 {
+		return v.errs
+	}
+	return nil
+}
+
+func (c *Config) validateNotifiers(v *validator) {
+	names := make(map[string]bool)
+	for i, nc := range c.Notifiers {
+		key := fmt.Sprintf("NOTIFIERS[%d]", i)
+		if nc.Name == "" {
+			v.add(key+".NAME", "is required")
+		} else if names[nc.Name] {
+			v.add(key+".NAME", "%q is defined more than once", nc.Name)
+		}
+		names[nc.Name] = true
+		v.oneOf(key+".TYPE", nc.Type, notifierTypes)
+		if nc.Type != "email" {
+			v.secretURL(key+".URL", nc.URL, true)
+			continue
+		}
+		v.required(key+".SMTP_HOST", nc.SMTPHost)
+		v.port(key+".SMTP_PORT", nc.SMTPPort)
+		v.required(key+".SMTP_FROM", nc.SMTPFrom)
+		if len(nc.SMTPTo) == 0 {
+			v.add(key+".SMTP_TO", "needs at least one recipient")
+		}
+	}
+
+	defined := make(map[string]bool)
+	for _, nc := range c.EffectiveNotifiers() {
+		defined[nc.Name] = true
+	}
+	for _, event := range sortedMapKeys(c.NotifyRoutes) {
+		key := "NOTIFY_ROUTES." + event
+		v.oneOf(key, event, notifyEvents)
+		for _, name := range c.NotifyRoutes[event] {
+			if !defined[name] {
+				v.add(key, "notifier %q is not defined in NOTIFIERS or by a built-in webhook", name)
+			}
+		}
+	}
+}
+
+func sortedMapKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
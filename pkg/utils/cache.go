@@ -1,130 +1,165 @@
 package utils
 
 import (
+    "encoding/binary"
     "encoding/json"
     "fmt"
-    "log"
-    "reflect"
+    "strconv"
+    "strings"
     "time"
-    
-    "go.etcd.io/bbolt"
+    "unicode"
+    "unicode/utf8"
 )
 
-// CacheItem 支持多种类型的缓存项
+// CacheItem 旧版 Set 写入的缓存项，值经过两次 JSON 编码，只用于迁移到命名空间存储桶
 type CacheItem struct {
     DataType   string
-    DataValue  interface{}
+    DataValue  json.RawMessage
     Expiration int64
 }
 
+// Cache 进程共享的状态存储，按命名空间划分，后端见 Store；类型化读写见 TypedBucket
 type Cache struct {
-    db         *bbolt.DB
-    bucketName []byte
+    store Store
 }
 
-func NewCache(dbPath, bucketName string) (*Cache, error) {
-    db, err := bbolt.Open(dbPath, 0600, &bbolt.Options{Timeout: 1 * time.Second})
-    if err != nil {
-        return nil, fmt.Errorf("打开数据库失败: %v", err)
+func NewCache(store Store) *Cache {
+    return &Cache{store: store}
+}
+
+func (c *Cache) Close() error {
+    return c.store.Close()
+}
+
+// TakeLegacy 读出旧版存储桶中的键并解码到 result，已过期的项视为不存在；
+// 调用方写入新位置后再调用 DropLegacy 删除
+func (c *Cache) TakeLegacy(key string, result interface{}) (bool, error) {
+    var item CacheItem
+    found, err := c.GetEntry(LegacyBucket, []byte(key), &item)
+    if err != nil || !found {
+        return false, err
     }
-    
-    err = db.Update(func(tx *bbolt.Tx) error {
-        _, err := tx.CreateBucketIfNotExists([]byte(bucketName))
-        return err
+    if item.Expiration > 0 && time.Now().Unix() > item.Expiration {
+        return false, nil
+    }
+    if err = json.Unmarshal(item.DataValue, result); err != nil {
+        return false, fmt.Errorf("legacy key %s: %w", key, err)
+    }
+    return true, nil
+}
+
+// LegacyKeys 旧版存储桶中以 prefix 开头的键
+func (c *Cache) LegacyKeys(prefix string) ([]string, error) {
+    var keys []string
+    err := c.Iterate(LegacyBucket, false, func(key, _ []byte) bool {
+        if strings.HasPrefix(string(key), prefix) {
+            keys = append(keys, string(key))
+        }
+        return true
     })
-    
+    return keys, err
+}
+
+func (c *Cache) DropLegacy(key string) error {
+    return c.DeleteEntry(LegacyBucket, []byte(key))
+}
+
+// Append 以自增序号为键向指定存储桶追加一条记录，适合只追加的日志类数据
+func (c *Cache) Append(bucketName string, value interface{}) (uint64, error) {
+    data, err := json.Marshal(value)
     if err != nil {
-        return nil, fmt.Errorf("创建存储桶失败: %v", err)
+        return 0, fmt.Errorf("序列化失败: %v", err)
     }
     
-    return &Cache{
-        db:         db,
-        bucketName: []byte(bucketName),
-    }, nil
+    var seq uint64
+    err = c.store.Update(func(tx StoreTx) error {
+        var err error
+        if seq, err = tx.NextSequence(bucketName); err != nil {
+            return err
+        }
+        return tx.Put(bucketName, SequenceKey(seq), data)
+    })
+    return seq, err
 }
 
-func (c *Cache) Set(key string, value interface{}, expiration int64) error {
-    t := reflect.TypeOf(value)
-    v := reflect.ValueOf(value)
-    
-    if v.Kind() == reflect.Ptr && v.IsNil() {
-        return fmt.Errorf("不支持存储 nil 指针")
-    }
-    
-    if expiration != 0 {
-        expiration = time.Now().Unix() + expiration
-    }
-    item := CacheItem{
-        DataType:   t.String(),
-        DataValue:  value,
-        Expiration: expiration,
-    }
-    
-    itemData, err := json.Marshal(item)
+// Iterate 遍历指定存储桶，reverse 为 true 时从最新的记录开始；fn 返回 false 时停止
+func (c *Cache) Iterate(bucketName string, reverse bool, fn func(key, value []byte) bool) error {
+    return c.store.View(func(tx StoreTx) error {
+        return tx.Scan(bucketName, nil, reverse, fn)
+    })
+}
+
+// PutEntry 以 JSON 写入指定存储桶的键，存储桶不存在时创建
+func (c *Cache) PutEntry(bucketName string, key []byte, value interface{}) error {
+    data, err := json.Marshal(value)
     if err != nil {
         return fmt.Errorf("序列化失败: %v", err)
     }
-    
-    return c.db.Update(func(tx *bbolt.Tx) error {
-        return tx.Bucket(c.bucketName).Put([]byte(key), itemData)
+    return c.store.Update(func(tx StoreTx) error {
+        return tx.Put(bucketName, key, data)
     })
 }
 
-func (c *Cache) Get(key string, result interface{}) error {
-    err := c.db.Update(func(tx *bbolt.Tx) error {
-        bucket := tx.Bucket(c.bucketName)
-        data := bucket.Get([]byte(key))
-        if data == nil {
-            return nil
+// GetEntry 读取指定存储桶的键，不存在时返回 false
+func (c *Cache) GetEntry(bucketName string, key []byte, result interface{}) (bool, error) {
+    var found bool
+    err := c.store.View(func(tx StoreTx) error {
+        data, err := tx.Get(bucketName, key)
+        if err != nil || data == nil {
+            return err
         }
-        
-        var item CacheItem
-        if err := json.Unmarshal(data, &item); err != nil {
+        found = true
+        if err := json.Unmarshal(data, result); err != nil {
             return fmt.Errorf("反序列化失败: %v", err)
         }
-        
-        if item.Expiration > 0 && time.Now().Unix() > item.Expiration {
-            return bucket.Delete([]byte(key))
-        }
-        
-        resultData, err := json.Marshal(item.DataValue)
-        if err != nil {
-            return fmt.Errorf("二次序列化失败: %v", err)
-        }
-        
-        if err = json.Unmarshal(resultData, result); err != nil {
-            return fmt.Errorf("类型转换失败: %v", err)
-        }
-        
         return nil
     })
-    return err
+    return found, err
 }
 
-func (c *Cache) Delete(key string) error {
-    return c.db.Update(func(tx *bbolt.Tx) error {
-        return tx.Bucket(c.bucketName).Delete([]byte(key))
+func (c *Cache) DeleteEntry(bucketName string, key []byte) error {
+    return c.store.Update(func(tx StoreTx) error {
+        if err := tx.Delete(bucketName, key); err != nil {
+            return err
+        }
+        return setExpiry(tx, bucketName, key, 0)
     })
 }
 
-func (c *Cache) Close() error {
-    return c.db.Close()
+func SequenceKey(seq uint64) []byte {
+    key := make([]byte, 8)
+    binary.BigEndian.PutUint64(key, seq)
+    return key
 }
 
-func (c *Cache) Flush() error {
-    return c.db.Update(func(tx *bbolt.Tx) error {
-        if err := tx.DeleteBucket(c.bucketName); err != nil {
-            return err
+// DisplayKey 自增序号键显示为 #序号，其余按字符串显示
+func DisplayKey(key []byte) string {
+    printable := utf8.Valid(key) && !strings.ContainsFunc(string(key), func(r rune) bool { return !unicode.IsPrint(r) })
+    if len(key) == 8 && !printable {
+        return fmt.Sprintf("#%d", binary.BigEndian.Uint64(key))
+    }
+    return string(key)
+}
+
+// ParseKey DisplayKey 的逆操作
+func ParseKey(display string) []byte {
+    if number, found := strings.CutPrefix(display, "#"); found {
+        if seq, err := strconv.ParseUint(number, 10, 64); err == nil {
+            return SequenceKey(seq)
         }
-        _, err := tx.CreateBucket(c.bucketName)
-        return err
-    })
+    }
+    return []byte(display)
 }
 
-func GetCache() *Cache {
-    cache, err := NewCache("cache.db", "support-workflow")
+// LegacyBucket 旧版 Set、Get 使用的存储桶，启动时迁移到各命名空间后清空
+const LegacyBucket = "support-workflow"
+
+// OpenCache 打开进程共享的缓存，整个进程只打开一次并在退出时关闭；
+// bolt 文件被其他进程（如正在运行的服务）占用时 1 秒后超时
+func OpenCache(backend, path string) (*Cache, error) {
+    store, err := OpenStore(backend, path)
     if err != nil {
-        log.Fatalf("Init cache failed: %v", err)
+        return nil, err
     }
-    return cache
+    return NewCache(store), nil
 }
@@ -0,0 +1,111 @@
+package utils
+
+import (
+    "bytes"
+    "encoding/binary"
+    "time"
+)
+
+const (
+    // ExpiryBucket 命名空间中设置了过期时间的键，键为 命名空间\x00键，值为过期时间
+    ExpiryBucket = "expiry"
+    // ExpiryIndexBucket 按过期时间排序的索引，键为 过期时间|命名空间\x00键，清理时从头扫描到当前时间即可
+    ExpiryIndexBucket = "expiry-index"
+
+    sweepBatchSize = 1000
+)
+
+func expiryRef(namespace string, key []byte) []byte {
+    ref := make([]byte, 0, len(namespace)+1+len(key))
+    ref = append(ref, namespace...)
+    ref = append(ref, 0)
+    return append(ref, key...)
+}
+
+func expiryIndexKey(expiresAt int64, ref []byte) []byte {
+    key := make([]byte, 8, 8+len(ref))
+    binary.BigEndian.PutUint64(key, uint64(expiresAt))
+    return append(key, ref...)
+}
+
+// expiresAt 键的过期时间，未设置时返回 0
+func expiresAt(tx StoreTx, namespace string, key []byte) int64 {
+    data, err := tx.Get(ExpiryBucket, expiryRef(namespace, key))
+    if err != nil || len(data) != 8 {
+        return 0
+    }
+    return int64(binary.BigEndian.Uint64(data))
+}
+
+func expired(tx StoreTx, namespace string, key []byte, now int64) bool {
+    at := expiresAt(tx, namespace, key)
+    return at > 0 && at <= now
+}
+
+// setExpiry 更新键的过期时间及索引，expiresAt 为 0 时清除
+func setExpiry(tx StoreTx, namespace string, key []byte, at int64) error {
+    ref := expiryRef(namespace, key)
+    if old := expiresAt(tx, namespace, key); old > 0 {
+        if err := tx.Delete(ExpiryIndexBucket, expiryIndexKey(old, ref)); err != nil {
+            return err
+        }
+        if err := tx.Delete(ExpiryBucket, ref); err != nil {
+            return err
+        }
+    }
+    if at <= 0 {
+        return nil
+    }
+    value := make([]byte, 8)
+    binary.BigEndian.PutUint64(value, uint64(at))
+    if err := tx.Put(ExpiryBucket, ref, value); err != nil {
+        return err
+    }
+    return tx.Put(ExpiryIndexBucket, expiryIndexKey(at, ref), []byte{})
+}
+
+// SweepExpired 删除过期时间不晚于 now 的键，返回各命名空间删除的数量；每个事务最多处理 sweepBatchSize 个键
+func (c *Cache) SweepExpired(now time.Time) (map[string]int, error) {
+    evicted := make(map[string]int)
+    for {
+        count, err := c.sweepBatch(now.Unix(), evicted)
+        if err != nil || count < sweepBatchSize {
+            return evicted, err
+        }
+    }
+}
+
+func (c *Cache) sweepBatch(now int64, evicted map[string]int) (int, error) {
+    count := 0
+    err := c.store.Update(func(tx StoreTx) error {
+        var due [][]byte
+        err := tx.Scan(ExpiryIndexBucket, nil, false, func(k, _ []byte) bool {
+            if int64(binary.BigEndian.Uint64(k[:8])) > now {
+                return false
+            }
+            due = append(due, append([]byte(nil), k...))
+            return len(due) < sweepBatchSize
+        })
+        if err != nil {
+            return err
+        }
+        for _, k := range due {
+            ref := k[8:]
+            sep := bytes.IndexByte(ref, 0)
+            namespace, key := string(ref[:sep]), ref[sep+1:]
+            if err = tx.Delete(namespace, key); err != nil {
+                return err
+            }
+            if err = tx.Delete(ExpiryIndexBucket, k); err != nil {
+                return err
+            }
+            if err = tx.Delete(ExpiryBucket, ref); err != nil {
+                return err
+            }
+            evicted[namespace] += 1
+        }
+        count = len(due)
+        return nil
+    })
+    return count, err
+}
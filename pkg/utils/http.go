@@ -6,6 +6,7 @@ import (
     "encoding/json"
     "fmt"
     "io"
+    "log"
     "net/http"
     
     "support-workflow/pkg/config"
@@ -113,7 +114,7 @@ func NewSupportClient() SupportClient {
         Client{
             baseURL: conf.SupportEndpoint, httpClient: &http.Client{},
             basicAuth: BasicAuth{
-                username: conf.SupportUsername, password: conf.SupportPassword,
+                username: conf.SupportUsername, password: conf.SupportPassword.Reveal(),
             },
         },
     }
@@ -130,7 +131,7 @@ type AccessTokenResponse struct {
 func (c *FeishuClient) GetAccessToken() string {
     conf := config.GetConf()
     body := larkauth.NewInternalAppAccessTokenReqBodyBuilder().
-        AppId(conf.FeishuAppID).AppSecret(conf.FeishuAppSecret).Build()
+        AppId(conf.FeishuAppID).AppSecret(conf.FeishuAppSecret.Reveal()).Build()
     req := larkauth.NewInternalAppAccessTokenReqBuilder().Body(body).Build()
     resp, err := c.Client.Auth.V3.AppAccessToken.Internal(context.Background(), req)
     if err != nil {
@@ -141,7 +142,7 @@ func (c *FeishuClient) GetAccessToken() string {
     }
     var response AccessTokenResponse
     if err = json.Unmarshal(resp.RawBody, &response); err != nil {
-        fmt.Println("解析 AccessToken 失败: %w", err)
+        log.Printf("解析 AccessToken 失败: %v", err)
         return ""
     }
     return response.AppAccessToken
@@ -149,6 +150,6 @@ func (c *FeishuClient) GetAccessToken() string {
 
 func NewFeishuClient() *FeishuClient {
     conf := config.GetConf()
-    baseClient := lark.NewClient(conf.FeishuAppID, conf.FeishuAppSecret)
+    baseClient := lark.NewClient(conf.FeishuAppID, conf.FeishuAppSecret.Reveal())
     return &FeishuClient{Client: baseClient}
 }
@@ -0,0 +1,250 @@
+package utils
+
+import (
+    "bytes"
+    "encoding/binary"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "os"
+    "strings"
+    "time"
+)
+
+const (
+    SnapshotVersion = 1
+
+    compactTxMaxSize = 64 << 20
+)
+
+// NamespaceInfo 命名空间及其中的键数
+type NamespaceInfo struct {
+    Name string `json:"name"`
+    Keys int    `json:"keys"`
+}
+
+// KeyInfo 键的概要，Type 为值的 JSON 类型，不是 JSON 时为 binary
+type KeyInfo struct {
+    Namespace string `json:"namespace"`
+    Key       string `json:"key"`
+    Type      string `json:"type"`
+    Size      int    `json:"size"`
+    ExpiresAt int64  `json:"expiresAt,omitempty"`
+}
+
+// SnapshotEntry 导出的一个键，ExpiresAt 为 0 表示不过期
+type SnapshotEntry struct {
+    Value     json.RawMessage `json:"value"`
+    ExpiresAt int64           `json:"expiresAt,omitempty"`
+}
+
+// Snapshot 整个缓存的导出，键为 DisplayKey；过期索引不导出，导入时根据 ExpiresAt 重建
+type Snapshot struct {
+    Version    int                                 `json:"version"`
+    ExportedAt int64                               `json:"exportedAt"`
+    Namespaces map[string]map[string]SnapshotEntry `json:"namespaces"`
+}
+
+func internalBucket(name string) bool {
+    return name == ExpiryBucket || name == ExpiryIndexBucket
+}
+
+func valueType(data []byte) string {
+    if !json.Valid(data) {
+        return "binary"
+    }
+    switch trimmed := bytes.TrimSpace(data); trimmed[0] {
+    case '{':
+        return "object"
+    case '[':
+        return "array"
+    case '"':
+        return "string"
+    case 't', 'f':
+        return "bool"
+    case 'n':
+        return "null"
+    }
+    return "number"
+}
+
+// rawValue 非 JSON 的值按字符串输出
+func rawValue(data []byte) json.RawMessage {
+    if !json.Valid(data) {
+        encoded, _ := json.Marshal(string(data))
+        return encoded
+    }
+    return append(json.RawMessage(nil), data...)
+}
+
+func keyInfo(tx StoreTx, namespace string, key, value []byte) KeyInfo {
+    return KeyInfo{
+        Namespace: namespace, Key: DisplayKey(key), Type: valueType(value),
+        Size: len(value), ExpiresAt: expiresAt(tx, namespace, key),
+    }
+}
+
+// Namespaces 按名称排序的全部命名空间，不包括过期索引
+func (c *Cache) Namespaces() ([]NamespaceInfo, error) {
+    namespaces := make([]NamespaceInfo, 0)
+    err := c.store.View(func(tx StoreTx) error {
+        names, err := tx.Namespaces()
+        if err != nil {
+            return err
+        }
+        for _, name := range names {
+            if internalBucket(name) {
+                continue
+            }
+            count, err := tx.Count(name)
+            if err != nil {
+                return err
+            }
+            namespaces = append(namespaces, NamespaceInfo{Name: name, Keys: count})
+        }
+        return nil
+    })
+    return namespaces, err
+}
+
+// Keys 按键排序返回命名空间中以 prefix 开头的键，包括已过期但尚未清理的键
+func (c *Cache) Keys(namespace, prefix string) ([]KeyInfo, error) {
+    keys := make([]KeyInfo, 0)
+    err := c.store.View(func(tx StoreTx) error {
+        return tx.Scan(namespace, []byte(prefix), false, func(k, v []byte) bool {
+            keys = append(keys, keyInfo(tx, namespace, k, v))
+            return true
+        })
+    })
+    return keys, err
+}
+
+// Lookup 读取一个键的概要和值，不存在时返回 nil
+func (c *Cache) Lookup(namespace string, key []byte) (*KeyInfo, json.RawMessage, error) {
+    var info *KeyInfo
+    var value json.RawMessage
+    err := c.store.View(func(tx StoreTx) error {
+        data, err := tx.Get(namespace, key)
+        if err != nil || data == nil {
+            return err
+        }
+        found := keyInfo(tx, namespace, key, data)
+        info, value = &found, rawValue(data)
+        return nil
+    })
+    return info, value, err
+}
+
+// Export 在一个只读事务中导出全部命名空间，服务运行时也可以调用
+func (c *Cache) Export() (*Snapshot, error) {
+    snapshot := &Snapshot{Version: SnapshotVersion, ExportedAt: time.Now().Unix(), Namespaces: make(map[string]map[string]SnapshotEntry)}
+    err := c.store.View(func(tx StoreTx) error {
+        names, err := tx.Namespaces()
+        if err != nil {
+            return err
+        }
+        for _, name := range names {
+            if internalBucket(name) {
+                continue
+            }
+            entries := make(map[string]SnapshotEntry)
+            err = tx.Scan(name, nil, false, func(k, v []byte) bool {
+                entries[DisplayKey(k)] = SnapshotEntry{Value: rawValue(v), ExpiresAt: expiresAt(tx, name, k)}
+                return true
+            })
+            if err != nil {
+                return err
+            }
+            snapshot.Namespaces[name] = entries
+        }
+        return nil
+    })
+    return snapshot, err
+}
+
+// Import 在一个事务中写入导出的数据，已过期的键跳过；replace 为 true 时先清空整个缓存，否则覆盖同名的键。
+// 自增序号会推进到导入的最大序号之后，避免之后追加的记录覆盖导入的记录
+func (c *Cache) Import(snapshot *Snapshot, replace bool) (int, error) {
+    if snapshot.Version != SnapshotVersion {
+        return 0, fmt.Errorf("unsupported snapshot version %d, expect %d", snapshot.Version, SnapshotVersion)
+    }
+    now := time.Now().Unix()
+    imported := 0
+    err := c.store.Update(func(tx StoreTx) error {
+        if replace {
+            names, err := tx.Namespaces()
+            if err != nil {
+                return err
+            }
+            for _, name := range names {
+                if err = tx.DeleteNamespace(name); err != nil {
+                    return err
+                }
+            }
+        }
+        for namespace, entries := range snapshot.Namespaces {
+            if internalBucket(namespace) || strings.ContainsRune(namespace, 0) {
+                return fmt.Errorf("invalid namespace %q", namespace)
+            }
+            for display, entry := range entries {
+                if entry.ExpiresAt > 0 && entry.ExpiresAt <= now {
+                    continue
+                }
+                if len(entry.Value) == 0 {
+                    return fmt.Errorf("%s/%s: value is missing", namespace, display)
+                }
+                key := ParseKey(display)
+                if err := tx.Put(namespace, key, entry.Value); err != nil {
+                    return fmt.Errorf("%s/%s: %w", namespace, display, err)
+                }
+                if err := setExpiry(tx, namespace, key, entry.ExpiresAt); err != nil {
+                    return err
+                }
+                if strings.HasPrefix(display, "#") && len(key) == 8 {
+                    if err := advanceSequence(tx, namespace, binary.BigEndian.Uint64(key)); err != nil {
+                        return err
+                    }
+                }
+                imported += 1
+            }
+        }
+        return nil
+    })
+    if err != nil {
+        return 0, err
+    }
+    return imported, nil
+}
+
+func advanceSequence(tx StoreTx, namespace string, seq uint64) error {
+    current, err := tx.Sequence(namespace)
+    if err != nil || current >= seq {
+        return err
+    }
+    return tx.SetSequence(namespace, seq)
+}
+
+// CompactFile 回收 bolt、sqlite 数据文件中删除数据占用的空间；bolt 需要独占文件，服务运行时不能执行。
+// 返回压缩前后的文件大小
+func CompactFile(backend, path string) (int64, int64, error) {
+    if backend == StoreMemory {
+        return 0, 0, errors.New("memory backend has no file to compact")
+    }
+    before, err := os.Stat(path)
+    if err != nil {
+        return 0, 0, err
+    }
+    if backend == StoreSQLite {
+        err = vacuumSQLiteFile(path)
+    } else {
+        err = compactBoltFile(path)
+    }
+    if err != nil {
+        return 0, 0, fmt.Errorf("compact %s failed: %w", path, err)
+    }
+    after, err := os.Stat(path)
+    if err != nil {
+        return 0, 0, err
+    }
+    return before.Size(), after.Size(), nil
+}
@@ -0,0 +1,337 @@
+package utils
+
+import (
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "mime"
+    "net/smtp"
+    "net/url"
+    "strings"
+    "time"
+
+    "support-workflow/pkg/config"
+)
+
+const (
+    EventOnboarding = "onboarding"
+    EventSync       = "sync"
+    EventReminder   = "reminder"
+    EventAlert      = "alert"
+
+    NotifierTypeWecom    = "wecom"
+    NotifierTypeFeishu   = "feishu"
+    NotifierTypeDingtalk = "dingtalk"
+    NotifierTypeWebhook  = "webhook"
+    NotifierTypeEmail    = "email"
+)
+
+type MessageField struct {
+    Key   string `json:"key"`
+    Value string `json:"value"`
+}
+
+// Message 与渠道无关的通知内容，各 Notifier 负责转换成自己的格式
+type Message struct {
+    Title          string         `json:"title"`
+    Content        string         `json:"content"`
+    Fields         []MessageField `json:"fields,omitempty"`
+    URL            string         `json:"url,omitempty"`
+    MentionMobiles []string       `json:"mentionMobiles"`
+    // Wecom 企业微信渠道优先发送的富文本消息，其他渠道使用 Text()
+    Wecom *WecomMessage `json:"wecom,omitempty"`
+}
+
+func (m *Message) Text() string {
+    var lines []string
+    if m.Title != "" {
+        lines = append(lines, m.Title)
+    }
+    if m.Content != "" {
+        lines = append(lines, m.Content)
+    }
+    for _, field := range m.Fields {
+        lines = append(lines, field.Key+": "+field.Value)
+    }
+    if m.URL != "" {
+        lines = append(lines, m.URL)
+    }
+    return strings.Join(lines, "\n")
+}
+
+type Notifier interface {
+    Name() string
+    Notify(msg Message) error
+}
+
+// robotResponse 企业微信、钉钉使用 errcode，飞书使用 code，成功时均为 0
+type robotResponse struct {
+    ErrCode int    `json:"errcode"`
+    ErrMsg  string `json:"errmsg"`
+    Code    int    `json:"code"`
+    Msg     string `json:"msg"`
+}
+
+func postRobot(webhookUrl string, body interface{}) error {
+    client := NewClient(webhookUrl)
+    var lastErr error
+    for i := 0; i < 5; i++ {
+        resp, err := client.Post("", body)
+        if err != nil {
+            lastErr = err
+            continue
+        }
+        data, err := io.ReadAll(resp.Body)
+        _ = resp.Body.Close()
+        if err != nil {
+            lastErr = err
+            continue
+        }
+        if resp.StatusCode != 200 {
+            lastErr = fmt.Errorf("请求失败，状态码: %d，响应: %s", resp.StatusCode, string(data))
+            continue
+        }
+        var robotResp robotResponse
+        if err = json.Unmarshal(data, &robotResp); err == nil {
+            if robotResp.ErrCode != 0 {
+                return fmt.Errorf("robot error %d: %s", robotResp.ErrCode, robotResp.ErrMsg)
+            }
+            if robotResp.Code != 0 {
+                return fmt.Errorf("robot error %d: %s", robotResp.Code, robotResp.Msg)
+            }
+        }
+        return nil
+    }
+    return lastErr
+}
+
+// WecomRobotNotifier 企业微信群机器人
+type WecomRobotNotifier struct {
+    name    string
+    webhook string
+}
+
+func (n *WecomRobotNotifier) Name() string {
+    return n.name
+}
+
+// Notify 发件箱按主消息是否送达决定重试；富文本消息无法按手机号 @，需要提醒时随后补发一条文本，
+// 补发失败只记录日志，不影响主消息，避免重试时重复发送
+func (n *WecomRobotNotifier) Notify(msg Message) error {
+    if msg.Wecom == nil {
+        return postRobot(n.webhook, NewWecomText(msg.Text(), msg.MentionMobiles...))
+    }
+    if err := postRobot(n.webhook, msg.Wecom); err != nil {
+        return err
+    }
+    if len(msg.MentionMobiles) == 0 || msg.Wecom.MsgType == WecomMsgTypeText {
+        return nil
+    }
+    if err := postRobot(n.webhook, NewWecomText(msg.Content, msg.MentionMobiles...)); err != nil {
+        log.Printf("Send mentions of %q to %s failed: %s", msg.Title, n.name, stripURLs(err.Error()))
+    }
+    return nil
+}
+
+// FeishuBotNotifier 飞书自定义机器人，配置了签名校验时需要 secret
+type FeishuBotNotifier struct {
+    name    string
+    webhook string
+    secret  string
+}
+
+func (n *FeishuBotNotifier) Name() string {
+    return n.name
+}
+
+func (n *FeishuBotNotifier) Notify(msg Message) error {
+    content := msg.Text()
+    if len(msg.MentionMobiles) > 0 {
+        content += "\n@" + strings.Join(msg.MentionMobiles, " @")
+    }
+    reqBody := map[string]interface{}{
+        "msg_type": "text",
+        "content":  map[string]interface{}{"text": content},
+    }
+    if n.secret != "" {
+        timestamp := time.Now().Unix()
+        sign, err := feishuSign(n.secret, timestamp)
+        if err != nil {
+            return err
+        }
+        reqBody["timestamp"] = fmt.Sprint(timestamp)
+        reqBody["sign"] = sign
+    }
+    return postRobot(n.webhook, reqBody)
+}
+
+// feishuSign 飞书以 timestamp + "\n" + secret 作为 HMAC-SHA256 的密钥，对空内容签名
+func feishuSign(secret string, timestamp int64) (string, error) {
+    stringToSign := fmt.Sprintf("%v\n%s", timestamp, secret)
+    h := hmac.New(sha256.New, []byte(stringToSign))
+    if _, err := h.Write([]byte{}); err != nil {
+        return "", err
+    }
+    return base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+// DingtalkRobotNotifier 钉钉群机器人，配置了加签时需要 secret
+type DingtalkRobotNotifier struct {
+    name    string
+    webhook string
+    secret  string
+}
+
+func (n *DingtalkRobotNotifier) Name() string {
+    return n.name
+}
+
+func (n *DingtalkRobotNotifier) Notify(msg Message) error {
+    content := msg.Text()
+    if len(msg.MentionMobiles) > 0 {
+        content += "\n@" + strings.Join(msg.MentionMobiles, " @")
+    }
+    reqBody := map[string]interface{}{
+        "msgtype": "text",
+        "text":    map[string]interface{}{"content": content},
+        "at":      map[string]interface{}{"atMobiles": msg.MentionMobiles},
+    }
+    webhookUrl := n.webhook
+    if n.secret != "" {
+        timestamp := time.Now().UnixMilli()
+        stringToSign := fmt.Sprintf("%d\n%s", timestamp, n.secret)
+        h := hmac.New(sha256.New, []byte(n.secret))
+        h.Write([]byte(stringToSign))
+        sign := base64.StdEncoding.EncodeToString(h.Sum(nil))
+        webhookUrl += fmt.Sprintf("&timestamp=%d&sign=%s", timestamp, url.QueryEscape(sign))
+    }
+    return postRobot(webhookUrl, reqBody)
+}
+
+// WebhookNotifier 通用 webhook，直接 POST Message 的 JSON
+type WebhookNotifier struct {
+    name string
+    url  string
+}
+
+func (n *WebhookNotifier) Name() string {
+    return n.name
+}
+
+func (n *WebhookNotifier) Notify(msg Message) error {
+    return postRobot(n.url, msg)
+}
+
+// EmailNotifier 通过 SMTP 发送纯文本邮件
+type EmailNotifier struct {
+    name     string
+    host     string
+    port     int
+    username string
+    password string
+    from     string
+    to       []string
+}
+
+func (n *EmailNotifier) Name() string {
+    return n.name
+}
+
+func (n *EmailNotifier) Notify(msg Message) error {
+    subject := msg.Title
+    if subject == "" {
+        subject = strings.SplitN(msg.Content, "\n", 2)[0]
+    }
+    var builder strings.Builder
+    builder.WriteString(fmt.Sprintf("From: %s\r\n", n.from))
+    builder.WriteString(fmt.Sprintf("To: %s\r\n", strings.Join(n.to, ", ")))
+    builder.WriteString(fmt.Sprintf("Subject: %s\r\n", mime.QEncoding.Encode("UTF-8", subject)))
+    builder.WriteString("MIME-Version: 1.0\r\n")
+    builder.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+    builder.WriteString(msg.Text())
+
+    var auth smtp.Auth
+    if n.username != "" {
+        auth = smtp.PlainAuth("", n.username, n.password, n.host)
+    }
+    addr := fmt.Sprintf("%s:%d", n.host, n.port)
+    return smtp.SendMail(addr, auth, n.from, n.to, []byte(builder.String()))
+}
+
+func NewNotifier(nc config.NotifierConfig) (Notifier, error) {
+    switch nc.Type {
+    case NotifierTypeWecom:
+        return &WecomRobotNotifier{name: nc.Name, webhook: nc.URL.Reveal()}, nil
+    case NotifierTypeFeishu:
+        return &FeishuBotNotifier{name: nc.Name, webhook: nc.URL.Reveal(), secret: nc.Secret.Reveal()}, nil
+    case NotifierTypeDingtalk:
+        return &DingtalkRobotNotifier{name: nc.Name, webhook: nc.URL.Reveal(), secret: nc.Secret.Reveal()}, nil
+    case NotifierTypeWebhook:
+        return &WebhookNotifier{name: nc.Name, url: nc.URL.Reveal()}, nil
+    case NotifierTypeEmail:
+        return &EmailNotifier{
+            name: nc.Name, host: nc.SMTPHost, port: nc.SMTPPort,
+            username: nc.SMTPUsername, password: nc.SMTPPassword.Reveal(),
+            from: nc.SMTPFrom, to: nc.SMTPTo,
+        }, nil
+    }
+    return nil, fmt.Errorf("notifier %s: unknown type %q", nc.Name, nc.Type)
+}
+
+func notifierConfigs() map[string]config.NotifierConfig {
+    conf := config.GetConf()
+    configs := make(map[string]config.NotifierConfig)
+    for _, nc := range conf.EffectiveNotifiers() {
+        configs[nc.Name] = nc
+    }
+    return configs
+}
+
+// FindNotifier 按名称查找通知渠道
+func FindNotifier(name string) (Notifier, error) {
+    nc, exists := notifierConfigs()[name]
+    if !exists {
+        return nil, fmt.Errorf("notifier %s not defined", name)
+    }
+    return NewNotifier(nc)
+}
+
+// EventNotifiers 返回事件对应的通知渠道
+func EventNotifiers(event string) ([]Notifier, error) {
+    conf := config.GetConf()
+    configs := notifierConfigs()
+    var notifiers []Notifier
+    for _, name := range conf.EffectiveNotifyRoutes()[event] {
+        nc, exists := configs[name]
+        if !exists {
+            return nil, fmt.Errorf("event %s: notifier %s not defined", event, name)
+        }
+        notifier, err := NewNotifier(nc)
+        if err != nil {
+            return nil, err
+        }
+        notifiers = append(notifiers, notifier)
+    }
+    return notifiers, nil
+}
+
+// Notify 同步把消息发送到事件配置的所有渠道，某个渠道失败不影响其他渠道；
+// 业务代码应使用 Enqueue 交给发件箱投递
+func Notify(event string, msg Message) error {
+    notifiers, err := EventNotifiers(event)
+    if err != nil {
+        return err
+    }
+    var errs []error
+    for _, notifier := range notifiers {
+        if err = notifier.Notify(msg); err != nil {
+            errs = append(errs, fmt.Errorf("%s: %w", notifier.Name(), err))
+        }
+    }
+    return errors.Join(errs...)
+}
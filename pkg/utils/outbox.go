@@ -0,0 +1,268 @@
+package utils
+
+import (
+    "encoding/binary"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log"
+    "net/url"
+    "regexp"
+    "sync"
+    "time"
+
+    "support-workflow/pkg/config"
+)
+
+const (
+    OutboxBucket     = "outbox"
+    OutboxDeadBucket = "outbox-dead"
+
+    OutboxStatusPending = "pending"
+    OutboxStatusDead    = "dead"
+
+    outboxPollInterval = 10 * time.Second
+    outboxBaseBackoff  = 30 * time.Second
+    outboxMaxBackoff   = 1 * time.Hour
+)
+
+// OutboxEntry 待投递到某个通知渠道的一条消息，同一消息发往多个渠道时每个渠道一条，互不影响
+type OutboxEntry struct {
+    ID          uint64  `json:"id"`
+    Event       string  `json:"event"`
+    Notifier    string  `json:"notifier"`
+    Message     Message `json:"message"`
+    Status      string  `json:"status"`
+    Attempts    int     `json:"attempts"`
+    LastError   string  `json:"lastError,omitempty"`
+    CreatedAt   int64   `json:"createdAt"`
+    NextAttempt int64   `json:"nextAttempt"`
+}
+
+var ErrOutboxEntryNotFound = errors.New("outbox entry not found")
+
+var urlPattern = regexp.MustCompile(`https?://[^\s"'<>]+`)
+
+// stripURLs 投递错误中常带有 webhook 地址，其中的 key 属于凭证，保存和展示前只保留协议和主机
+func stripURLs(message string) string {
+    return urlPattern.ReplaceAllStringFunc(message, func(raw string) string {
+        u, err := url.Parse(raw)
+        if err != nil || u.Host == "" {
+            return "<url>"
+        }
+        return u.Scheme + "://" + u.Host
+    })
+}
+
+// Outbox 发件箱，消息先写入缓存再由后台投递
+type Outbox struct {
+    cache  *Cache
+    mu     sync.Mutex
+    ticker *time.Ticker
+    stop   chan struct{}
+    // done 后台投递退出后关闭，Stop 等待正在进行的投递结束，避免关闭缓存后仍在写入
+    done chan struct{}
+    // wake 入队后唤醒投递，不必等到下一次轮询
+    wake chan struct{}
+}
+
+func NewOutbox(cache *Cache) *Outbox {
+    return &Outbox{cache: cache, stop: make(chan struct{}), wake: make(chan struct{}, 1)}
+}
+
+func (o *Outbox) wakeUp() {
+    select {
+    case o.wake <- struct{}{}:
+    default:
+    }
+}
+
+// Enqueue 把消息按事件路由写入发件箱，由后台投递；只有配置或存储出错时返回错误
+func (o *Outbox) Enqueue(event string, msg Message) error {
+    notifiers, err := EventNotifiers(event)
+    if err != nil {
+        return err
+    }
+    if len(notifiers) == 0 {
+        log.Printf("No notifier routed for event %s, drop message: %s", event, msg.Title)
+        return nil
+    }
+    now := time.Now().Unix()
+    for _, notifier := range notifiers {
+        entry := OutboxEntry{
+            Event: event, Notifier: notifier.Name(), Message: msg,
+            Status: OutboxStatusPending, CreatedAt: now, NextAttempt: now,
+        }
+        if err = appendOutboxEntry(o.cache, &entry); err != nil {
+            return fmt.Errorf("enqueue %s message failed: %w", event, err)
+        }
+    }
+    o.wakeUp()
+    return nil
+}
+
+// appendOutboxEntry 使用存储桶自增序号作为 ID，写入前先占用序号以便记录 ID
+func appendOutboxEntry(cache *Cache, entry *OutboxEntry) error {
+    id, err := cache.Append(OutboxBucket, entry)
+    if err != nil {
+        return err
+    }
+    entry.ID = id
+    return cache.PutEntry(OutboxBucket, SequenceKey(id), entry)
+}
+
+func outboxBackoff(attempts int) time.Duration {
+    backoff := outboxBaseBackoff
+    for i := 1; i < attempts && backoff < outboxMaxBackoff; i++ {
+        backoff *= 2
+    }
+    if backoff > outboxMaxBackoff {
+        backoff = outboxMaxBackoff
+    }
+    return backoff
+}
+
+func (o *Outbox) Start() {
+    o.ticker = time.NewTicker(outboxPollInterval)
+    o.done = make(chan struct{})
+    go func() {
+        defer close(o.done)
+        for {
+            select {
+            case <-o.stop:
+                return
+            case <-o.ticker.C:
+            case <-o.wake:
+            }
+            o.Deliver()
+        }
+    }()
+}
+
+// Stop 停止后台投递并等待正在进行的一轮投递结束
+func (o *Outbox) Stop() {
+    if o.ticker != nil {
+        o.ticker.Stop()
+    }
+    close(o.stop)
+    if o.done != nil {
+        <-o.done
+    }
+}
+
+// Deliver 投递所有到期的消息
+func (o *Outbox) Deliver() {
+    o.mu.Lock()
+    defer o.mu.Unlock()
+
+    now := time.Now()
+    due, err := o.List(OutboxStatusPending)
+    if err != nil {
+        log.Printf("Load outbox failed: %v", err)
+        return
+    }
+    maxAttempts := config.GetConf().OutboxMaxAttempts
+    for _, entry := range due {
+        if entry.NextAttempt > now.Unix() {
+            continue
+        }
+        notifier, err := FindNotifier(entry.Notifier)
+        if err == nil {
+            err = notifier.Notify(entry.Message)
+        }
+        entry.Attempts += 1
+        if err == nil {
+            if err = o.cache.DeleteEntry(OutboxBucket, SequenceKey(entry.ID)); err != nil {
+                log.Printf("Remove delivered outbox entry %d failed: %v", entry.ID, err)
+            }
+            continue
+        }
+        entry.LastError = stripURLs(err.Error())
+        if entry.Attempts >= maxAttempts {
+            log.Printf("Outbox entry %d to %s dead after %d attempts: %s", entry.ID, entry.Notifier, entry.Attempts, entry.LastError)
+            err = o.move(entry, OutboxBucket, OutboxDeadBucket, OutboxStatusDead)
+        } else {
+            entry.NextAttempt = now.Add(outboxBackoff(entry.Attempts)).Unix()
+            log.Printf("Deliver outbox entry %d to %s failed, attempt %d: %s", entry.ID, entry.Notifier, entry.Attempts, entry.LastError)
+            err = o.cache.PutEntry(OutboxBucket, SequenceKey(entry.ID), entry)
+        }
+        if err != nil {
+            log.Printf("Save outbox entry %d failed: %v", entry.ID, err)
+        }
+    }
+}
+
+func (o *Outbox) move(entry OutboxEntry, from, to, status string) error {
+    entry.Status = status
+    if err := o.cache.PutEntry(to, SequenceKey(entry.ID), entry); err != nil {
+        return err
+    }
+    return o.cache.DeleteEntry(from, SequenceKey(entry.ID))
+}
+
+func outboxBucketOf(status string) (string, error) {
+    switch status {
+    case OutboxStatusPending:
+        return OutboxBucket, nil
+    case OutboxStatusDead:
+        return OutboxDeadBucket, nil
+    }
+    return "", fmt.Errorf("unknown outbox status %q", status)
+}
+
+// List 按 ID 升序列出待投递或死信消息
+func (o *Outbox) List(status string) ([]OutboxEntry, error) {
+    bucketName, err := outboxBucketOf(status)
+    if err != nil {
+        return nil, err
+    }
+    entries := make([]OutboxEntry, 0)
+    var decodeErr error
+    err = o.cache.Iterate(bucketName, false, func(key, value []byte) bool {
+        var entry OutboxEntry
+        id := binary.BigEndian.Uint64(key)
+        if decodeErr = json.Unmarshal(value, &entry); decodeErr != nil {
+            decodeErr = fmt.Errorf("outbox entry %d: %w", id, decodeErr)
+            return false
+        }
+        entry.ID = id
+        entries = append(entries, entry)
+        return true
+    })
+    if err != nil {
+        return nil, err
+    }
+    return entries, decodeErr
+}
+
+// Resend 死信重新入队并清零重试次数，待投递的消息立即重试
+func (o *Outbox) Resend(id uint64) error {
+    var entry OutboxEntry
+    from := ""
+    for _, bucketName := range []string{OutboxDeadBucket, OutboxBucket} {
+        found, err := o.cache.GetEntry(bucketName, SequenceKey(id), &entry)
+        if err != nil {
+            return err
+        }
+        if found {
+            from = bucketName
+            break
+        }
+    }
+    if from == "" {
+        return fmt.Errorf("%w: %d", ErrOutboxEntryNotFound, id)
+    }
+    var err error
+    entry.NextAttempt = time.Now().Unix()
+    if from == OutboxDeadBucket {
+        entry.Attempts = 0
+        err = o.move(entry, OutboxDeadBucket, OutboxBucket, OutboxStatusPending)
+    } else {
+        err = o.cache.PutEntry(OutboxBucket, SequenceKey(id), entry)
+    }
+    if err != nil {
+        return err
+    }
+    o.wakeUp()
+    return nil
+}
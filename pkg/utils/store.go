@@ -0,0 +1,67 @@
+package utils
+
+import (
+    "bytes"
+    "errors"
+    "fmt"
+)
+
+const (
+    StoreBolt   = "bolt"
+    StoreSQLite = "sqlite"
+    StoreMemory = "memory"
+)
+
+var errReadOnlyTx = errors.New("write in a read-only transaction")
+
+// Store 状态存储的后端，数据按命名空间划分，命名空间内的键按字节序排列；
+// 同一时刻最多一个写事务，事务中的函数返回错误时全部修改回滚
+type Store interface {
+    View(fn func(tx StoreTx) error) error
+    Update(fn func(tx StoreTx) error) error
+    Close() error
+}
+
+// StoreTx 事务内的读写，只读事务中调用写方法返回错误；返回的值只在事务内有效
+type StoreTx interface {
+    // Get 读取一个键，不存在时返回 nil
+    Get(namespace string, key []byte) ([]byte, error)
+    // Put 写入一个键，命名空间不存在时创建
+    Put(namespace string, key, value []byte) error
+    Delete(namespace string, key []byte) error
+    // Scan 按键的顺序遍历以 prefix 开头的键，reverse 为 true 时从大到小；fn 返回 false 时停止，遍历中不能修改同一命名空间
+    Scan(namespace string, prefix []byte, reverse bool, fn func(key, value []byte) bool) error
+    // Namespaces 按名称排序的全部命名空间
+    Namespaces() ([]string, error)
+    Count(namespace string) (int, error)
+    DeleteNamespace(namespace string) error
+    // NextSequence 命名空间的自增序号加一并返回，用于只追加的日志类数据
+    NextSequence(namespace string) (uint64, error)
+    Sequence(namespace string) (uint64, error)
+    SetSequence(namespace string, seq uint64) error
+}
+
+// OpenStore 按 backend 打开存储，path 为 bolt、sqlite 的数据文件，memory 不使用
+func OpenStore(backend, path string) (Store, error) {
+    switch backend {
+    case StoreBolt, "":
+        return openBoltStore(path)
+    case StoreSQLite:
+        return openSQLiteStore(path)
+    case StoreMemory:
+        return newMemoryStore(), nil
+    }
+    return nil, fmt.Errorf("unknown cache backend %q", backend)
+}
+
+// prefixEnd 大于所有以 prefix 开头的键的最小值，prefix 为空或全为 0xff 时返回 nil
+func prefixEnd(prefix []byte) []byte {
+    end := bytes.Clone(prefix)
+    for i := len(end) - 1; i >= 0; i-- {
+        if end[i] < 0xff {
+            end[i] += 1
+            return end[:i+1]
+        }
+    }
+    return nil
+}
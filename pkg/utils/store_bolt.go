@@ -0,0 +1,171 @@
+package utils
+
+import (
+    "bytes"
+    "errors"
+    "fmt"
+    "os"
+    "time"
+
+    "go.etcd.io/bbolt"
+)
+
+// boltStore 基于 bbolt 的存储，一个命名空间对应一个存储桶；文件由进程独占
+type boltStore struct {
+    db *bbolt.DB
+}
+
+type boltTx struct {
+    tx *bbolt.Tx
+}
+
+func openBoltStore(path string) (*boltStore, error) {
+    db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: 1 * time.Second})
+    if errors.Is(err, bbolt.ErrTimeout) {
+        return nil, fmt.Errorf("%s is locked by another process, stop the running service first: %w", path, err)
+    }
+    if err != nil {
+        return nil, fmt.Errorf("打开数据库失败: %w", err)
+    }
+    return &boltStore{db: db}, nil
+}
+
+func (s *boltStore) View(fn func(tx StoreTx) error) error {
+    return s.db.View(func(tx *bbolt.Tx) error {
+        return fn(&boltTx{tx: tx})
+    })
+}
+
+func (s *boltStore) Update(fn func(tx StoreTx) error) error {
+    return s.db.Update(func(tx *bbolt.Tx) error {
+        return fn(&boltTx{tx: tx})
+    })
+}
+
+func (s *boltStore) Close() error {
+    return s.db.Close()
+}
+
+func (t *boltTx) Get(namespace string, key []byte) ([]byte, error) {
+    bucket := t.tx.Bucket([]byte(namespace))
+    if bucket == nil {
+        return nil, nil
+    }
+    return bucket.Get(key), nil
+}
+
+func (t *boltTx) Put(namespace string, key, value []byte) error {
+    bucket, err := t.tx.CreateBucketIfNotExists([]byte(namespace))
+    if err != nil {
+        return err
+    }
+    return bucket.Put(key, value)
+}
+
+func (t *boltTx) Delete(namespace string, key []byte) error {
+    bucket := t.tx.Bucket([]byte(namespace))
+    if bucket == nil {
+        return nil
+    }
+    return bucket.Delete(key)
+}
+
+func (t *boltTx) Scan(namespace string, prefix []byte, reverse bool, fn func(key, value []byte) bool) error {
+    bucket := t.tx.Bucket([]byte(namespace))
+    if bucket == nil {
+        return nil
+    }
+    cursor := bucket.Cursor()
+    if !reverse {
+        for k, v := cursor.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = cursor.Next() {
+            if !fn(k, v) {
+                break
+            }
+        }
+        return nil
+    }
+    var k, v []byte
+    if end := prefixEnd(prefix); end == nil {
+        k, v = cursor.Last()
+    } else if k, v = cursor.Seek(end); k == nil {
+        k, v = cursor.Last()
+    } else {
+        k, v = cursor.Prev()
+    }
+    for ; k != nil && bytes.HasPrefix(k, prefix); k, v = cursor.Prev() {
+        if !fn(k, v) {
+            break
+        }
+    }
+    return nil
+}
+
+func (t *boltTx) Namespaces() ([]string, error) {
+    var names []string
+    err := t.tx.ForEach(func(name []byte, _ *bbolt.Bucket) error {
+        names = append(names, string(name))
+        return nil
+    })
+    return names, err
+}
+
+func (t *boltTx) Count(namespace string) (int, error) {
+    bucket := t.tx.Bucket([]byte(namespace))
+    if bucket == nil {
+        return 0, nil
+    }
+    return bucket.Stats().KeyN, nil
+}
+
+func (t *boltTx) DeleteNamespace(namespace string) error {
+    err := t.tx.DeleteBucket([]byte(namespace))
+    if errors.Is(err, bbolt.ErrBucketNotFound) {
+        return nil
+    }
+    return err
+}
+
+func (t *boltTx) NextSequence(namespace string) (uint64, error) {
+    bucket, err := t.tx.CreateBucketIfNotExists([]byte(namespace))
+    if err != nil {
+        return 0, err
+    }
+    return bucket.NextSequence()
+}
+
+func (t *boltTx) Sequence(namespace string) (uint64, error) {
+    bucket := t.tx.Bucket([]byte(namespace))
+    if bucket == nil {
+        return 0, nil
+    }
+    return bucket.Sequence(), nil
+}
+
+func (t *boltTx) SetSequence(namespace string, seq uint64) error {
+    bucket, err := t.tx.CreateBucketIfNotExists([]byte(namespace))
+    if err != nil {
+        return err
+    }
+    return bucket.SetSequence(seq)
+}
+
+// compactBoltFile 压缩复制后替换原文件
+func compactBoltFile(path string) error {
+    src, err := openBoltStore(path)
+    if err != nil {
+        return err
+    }
+    tmpPath := path + ".compact"
+    _ = os.Remove(tmpPath)
+    dst, err := bbolt.Open(tmpPath, 0600, &bbolt.Options{Timeout: 1 * time.Second})
+    if err != nil {
+        _ = src.Close()
+        return fmt.Errorf("打开数据库失败: %w", err)
+    }
+    err = bbolt.Compact(dst, src.db, compactTxMaxSize)
+    if err = errors.Join(err, dst.Close(), src.Close()); err != nil {
+        _ = os.Remove(tmpPath)
+        return err
+    }
+    return os.Rename(tmpPath, path)
+}
@@ -0,0 +1,198 @@
+package utils
+
+import (
+    "bytes"
+    "sort"
+    "sync"
+)
+
+// memoryStore 进程内存储，进程退出后数据丢失，用于测试和试运行；
+// 写事务记录撤销操作，出错时按相反顺序撤销
+type memoryStore struct {
+    mu         sync.RWMutex
+    namespaces map[string]*memoryNamespace
+}
+
+type memoryNamespace struct {
+    entries  map[string][]byte
+    sequence uint64
+}
+
+type memoryTx struct {
+    store    *memoryStore
+    writable bool
+    undo     []func()
+}
+
+func newMemoryStore() *memoryStore {
+    return &memoryStore{namespaces: make(map[string]*memoryNamespace)}
+}
+
+func (s *memoryStore) View(fn func(tx StoreTx) error) error {
+    s.mu.RLock()
+    defer s.mu.RUnlock()
+    return fn(&memoryTx{store: s})
+}
+
+func (s *memoryStore) Update(fn func(tx StoreTx) error) (err error) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    tx := &memoryTx{store: s, writable: true}
+    defer func() {
+        if recovered := recover(); recovered != nil {
+            tx.rollback()
+            panic(recovered)
+        }
+        if err != nil {
+            tx.rollback()
+        }
+    }()
+    return fn(tx)
+}
+
+func (s *memoryStore) Close() error {
+    return nil
+}
+
+func (t *memoryTx) rollback() {
+    for i := len(t.undo) - 1; i >= 0; i-- {
+        t.undo[i]()
+    }
+}
+
+// namespace 写入时命名空间不存在则创建
+func (t *memoryTx) namespace(name string, create bool) *memoryNamespace {
+    ns, exists := t.store.namespaces[name]
+    if !exists && create {
+        ns = &memoryNamespace{entries: make(map[string][]byte)}
+        t.store.namespaces[name] = ns
+        t.undo = append(t.undo, func() { delete(t.store.namespaces, name) })
+    }
+    return ns
+}
+
+func (t *memoryTx) Get(namespace string, key []byte) ([]byte, error) {
+    ns := t.namespace(namespace, false)
+    if ns == nil {
+        return nil, nil
+    }
+    return ns.entries[string(key)], nil
+}
+
+func (t *memoryTx) Put(namespace string, key, value []byte) error {
+    if !t.writable {
+        return errReadOnlyTx
+    }
+    ns := t.namespace(namespace, true)
+    old, existed := ns.entries[string(key)]
+    ns.entries[string(key)] = append([]byte{}, value...)
+    t.undo = append(t.undo, func() {
+        if existed {
+            ns.entries[string(key)] = old
+        } else {
+            delete(ns.entries, string(key))
+        }
+    })
+    return nil
+}
+
+func (t *memoryTx) Delete(namespace string, key []byte) error {
+    if !t.writable {
+        return errReadOnlyTx
+    }
+    ns := t.namespace(namespace, false)
+    if ns == nil {
+        return nil
+    }
+    old, existed := ns.entries[string(key)]
+    if !existed {
+        return nil
+    }
+    delete(ns.entries, string(key))
+    t.undo = append(t.undo, func() { ns.entries[string(key)] = old })
+    return nil
+}
+
+func (t *memoryTx) Scan(namespace string, prefix []byte, reverse bool, fn func(key, value []byte) bool) error {
+    ns := t.namespace(namespace, false)
+    if ns == nil {
+        return nil
+    }
+    keys := make([]string, 0, len(ns.entries))
+    for key := range ns.entries {
+        if bytes.HasPrefix([]byte(key), prefix) {
+            keys = append(keys, key)
+        }
+    }
+    sort.Strings(keys)
+    if reverse {
+        for i, j := 0, len(keys)-1; i < j; i, j = i+1, j-1 {
+            keys[i], keys[j] = keys[j], keys[i]
+        }
+    }
+    for _, key := range keys {
+        if !fn([]byte(key), ns.entries[key]) {
+            break
+        }
+    }
+    return nil
+}
+
+func (t *memoryTx) Namespaces() ([]string, error) {
+    names := make([]string, 0, len(t.store.namespaces))
+    for name := range t.store.namespaces {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names, nil
+}
+
+func (t *memoryTx) Count(namespace string) (int, error) {
+    ns := t.namespace(namespace, false)
+    if ns == nil {
+        return 0, nil
+    }
+    return len(ns.entries), nil
+}
+
+func (t *memoryTx) DeleteNamespace(namespace string) error {
+    if !t.writable {
+        return errReadOnlyTx
+    }
+    ns := t.namespace(namespace, false)
+    if ns == nil {
+        return nil
+    }
+    delete(t.store.namespaces, namespace)
+    t.undo = append(t.undo, func() { t.store.namespaces[namespace] = ns })
+    return nil
+}
+
+func (t *memoryTx) NextSequence(namespace string) (uint64, error) {
+    if !t.writable {
+        return 0, errReadOnlyTx
+    }
+    ns := t.namespace(namespace, true)
+    ns.sequence += 1
+    t.undo = append(t.undo, func() { ns.sequence -= 1 })
+    return ns.sequence, nil
+}
+
+func (t *memoryTx) Sequence(namespace string) (uint64, error) {
+    ns := t.namespace(namespace, false)
+    if ns == nil {
+        return 0, nil
+    }
+    return ns.sequence, nil
+}
+
+func (t *memoryTx) SetSequence(namespace string, seq uint64) error {
+    if !t.writable {
+        return errReadOnlyTx
+    }
+    ns := t.namespace(namespace, true)
+    old := ns.sequence
+    ns.sequence = seq
+    t.undo = append(t.undo, func() { ns.sequence = old })
+    return nil
+}
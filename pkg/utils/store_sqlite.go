@@ -0,0 +1,257 @@
+package utils
+
+import (
+    "bytes"
+    "context"
+    "database/sql"
+    "errors"
+    "fmt"
+    "os"
+    "unicode/utf8"
+
+    _ "modernc.org/sqlite"
+)
+
+// sqliteScanPage Scan 每次查询的行数，分页读取避免遍历时持有查询结果
+const sqliteScanPage = 256
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS entries (
+    namespace TEXT NOT NULL,
+    key       BLOB NOT NULL,
+    value     BLOB NOT NULL,
+    PRIMARY KEY (namespace, key)
+) WITHOUT ROWID;
+CREATE TABLE IF NOT EXISTS sequences (
+    namespace TEXT PRIMARY KEY,
+    value     INTEGER NOT NULL
+);`
+
+// sqliteStore 基于 SQLite 的存储，所有命名空间在 entries 一张表中，JSON 值以文本保存，可以直接用 SQL 查看，例如
+// SELECT CAST(key AS TEXT), value FROM entries WHERE namespace = 'checkpoints'；
+// 进程内只用一个连接，事务依次执行，其他进程可以同时读取
+type sqliteStore struct {
+    db *sql.DB
+}
+
+type sqliteTx struct {
+    tx       *sql.Tx
+    readOnly bool
+}
+
+func openSQLiteStore(path string) (*sqliteStore, error) {
+    db, err := sql.Open("sqlite", fmt.Sprintf("file:%s?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)", path))
+    if err != nil {
+        return nil, fmt.Errorf("打开数据库失败: %w", err)
+    }
+    db.SetMaxOpenConns(1)
+    if _, err = db.Exec(sqliteSchema); err != nil {
+        _ = db.Close()
+        return nil, fmt.Errorf("打开数据库失败: %w", err)
+    }
+    return &sqliteStore{db: db}, nil
+}
+
+func (s *sqliteStore) run(readOnly bool, fn func(tx StoreTx) error) error {
+    tx, err := s.db.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: readOnly})
+    if err != nil {
+        return err
+    }
+    if err = fn(&sqliteTx{tx: tx, readOnly: readOnly}); err != nil {
+        return errors.Join(err, tx.Rollback())
+    }
+    return tx.Commit()
+}
+
+func (s *sqliteStore) View(fn func(tx StoreTx) error) error {
+    return s.run(true, fn)
+}
+
+func (s *sqliteStore) Update(fn func(tx StoreTx) error) error {
+    return s.run(false, fn)
+}
+
+func (s *sqliteStore) Close() error {
+    return s.db.Close()
+}
+
+// sqliteValue JSON 等文本以 TEXT 保存便于用 SQL 查看，其余以 BLOB 保存
+func sqliteValue(value []byte) interface{} {
+    if utf8.Valid(value) && bytes.IndexByte(value, 0) < 0 {
+        return string(value)
+    }
+    return append([]byte{}, value...)
+}
+
+func (t *sqliteTx) Get(namespace string, key []byte) ([]byte, error) {
+    var value []byte
+    err := t.tx.QueryRow(`SELECT value FROM entries WHERE namespace = ? AND key = ?`, namespace, key).Scan(&value)
+    if errors.Is(err, sql.ErrNoRows) {
+        return nil, nil
+    }
+    if value == nil && err == nil {
+        value = []byte{}
+    }
+    return value, err
+}
+
+func (t *sqliteTx) Put(namespace string, key, value []byte) error {
+    if t.readOnly {
+        return errReadOnlyTx
+    }
+    _, err := t.tx.Exec(`INSERT INTO entries (namespace, key, value) VALUES (?, ?, ?)
+        ON CONFLICT (namespace, key) DO UPDATE SET value = excluded.value`, namespace, key, sqliteValue(value))
+    return err
+}
+
+func (t *sqliteTx) Delete(namespace string, key []byte) error {
+    if t.readOnly {
+        return errReadOnlyTx
+    }
+    _, err := t.tx.Exec(`DELETE FROM entries WHERE namespace = ? AND key = ?`, namespace, key)
+    return err
+}
+
+type sqliteRow struct {
+    key, value []byte
+}
+
+// scanPage 读取 after 之后（reverse 时之前）的一页，after 为 nil 时从 prefix 的边界开始
+func (t *sqliteTx) scanPage(namespace string, prefix, after []byte, reverse bool) ([]sqliteRow, error) {
+    query := `SELECT key, value FROM entries WHERE namespace = ?`
+    args := []interface{}{namespace}
+    end := prefixEnd(prefix)
+    switch {
+    case !reverse && after != nil:
+        query += ` AND key > ?`
+        args = append(args, after)
+    case !reverse:
+        query += ` AND key >= ?`
+        args = append(args, append([]byte{}, prefix...))
+    case after != nil:
+        query += ` AND key < ?`
+        args = append(args, after)
+    case end != nil:
+        query += ` AND key < ?`
+        args = append(args, end)
+    }
+    if reverse {
+        query += ` AND key >= ? ORDER BY key DESC LIMIT ?`
+        args = append(args, append([]byte{}, prefix...), sqliteScanPage)
+    } else if end != nil {
+        query += ` AND key < ? ORDER BY key LIMIT ?`
+        args = append(args, end, sqliteScanPage)
+    } else {
+        query += ` ORDER BY key LIMIT ?`
+        args = append(args, sqliteScanPage)
+    }
+
+    rows, err := t.tx.Query(query, args...)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    var page []sqliteRow
+    for rows.Next() {
+        var row sqliteRow
+        if err = rows.Scan(&row.key, &row.value); err != nil {
+            return nil, err
+        }
+        page = append(page, row)
+    }
+    return page, rows.Err()
+}
+
+func (t *sqliteTx) Scan(namespace string, prefix []byte, reverse bool, fn func(key, value []byte) bool) error {
+    var after []byte
+    for {
+        page, err := t.scanPage(namespace, prefix, after, reverse)
+        if err != nil {
+            return err
+        }
+        for _, row := range page {
+            if !fn(row.key, row.value) {
+                return nil
+            }
+        }
+        if len(page) < sqliteScanPage {
+            return nil
+        }
+        after = page[len(page)-1].key
+    }
+}
+
+func (t *sqliteTx) Namespaces() ([]string, error) {
+    rows, err := t.tx.Query(`SELECT namespace FROM entries UNION SELECT namespace FROM sequences ORDER BY 1`)
+    if err != nil {
+        return nil, err
+    }
+    defer rows.Close()
+    var names []string
+    for rows.Next() {
+        var name string
+        if err = rows.Scan(&name); err != nil {
+            return nil, err
+        }
+        names = append(names, name)
+    }
+    return names, rows.Err()
+}
+
+func (t *sqliteTx) Count(namespace string) (int, error) {
+    var count int
+    err := t.tx.QueryRow(`SELECT COUNT(*) FROM entries WHERE namespace = ?`, namespace).Scan(&count)
+    return count, err
+}
+
+func (t *sqliteTx) DeleteNamespace(namespace string) error {
+    if t.readOnly {
+        return errReadOnlyTx
+    }
+    if _, err := t.tx.Exec(`DELETE FROM entries WHERE namespace = ?`, namespace); err != nil {
+        return err
+    }
+    _, err := t.tx.Exec(`DELETE FROM sequences WHERE namespace = ?`, namespace)
+    return err
+}
+
+func (t *sqliteTx) NextSequence(namespace string) (uint64, error) {
+    if t.readOnly {
+        return 0, errReadOnlyTx
+    }
+    var seq uint64
+    err := t.tx.QueryRow(`INSERT INTO sequences (namespace, value) VALUES (?, 1)
+        ON CONFLICT (namespace) DO UPDATE SET value = value + 1 RETURNING value`, namespace).Scan(&seq)
+    return seq, err
+}
+
+func (t *sqliteTx) Sequence(namespace string) (uint64, error) {
+    var seq uint64
+    err := t.tx.QueryRow(`SELECT value FROM sequences WHERE namespace = ?`, namespace).Scan(&seq)
+    if errors.Is(err, sql.ErrNoRows) {
+        return 0, nil
+    }
+    return seq, err
+}
+
+func (t *sqliteTx) SetSequence(namespace string, seq uint64) error {
+    if t.readOnly {
+        return errReadOnlyTx
+    }
+    _, err := t.tx.Exec(`INSERT INTO sequences (namespace, value) VALUES (?, ?)
+        ON CONFLICT (namespace) DO UPDATE SET value = excluded.value`, namespace, seq)
+    return err
+}
+
+// vacuumSQLiteFile 重建数据库文件回收空间
+func vacuumSQLiteFile(path string) error {
+    if _, err := os.Stat(path); err != nil {
+        return err
+    }
+    store, err := openSQLiteStore(path)
+    if err != nil {
+        return err
+    }
+    _, err = store.db.Exec(`VACUUM`)
+    return errors.Join(err, store.Close())
+}
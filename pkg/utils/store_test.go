@@ -0,0 +1,346 @@
+package utils
+
+import (
+    "errors"
+    "fmt"
+    "path/filepath"
+    "reflect"
+    "testing"
+    "time"
+)
+
+// forEachStore 对每种后端各打开一个空存储执行 fn，所有后端应当表现一致
+func forEachStore(t *testing.T, fn func(t *testing.T, store Store)) {
+    for _, backend := range []string{StoreMemory, StoreBolt, StoreSQLite} {
+        t.Run(backend, func(t *testing.T) {
+            store, err := OpenStore(backend, filepath.Join(t.TempDir(), "cache.db"))
+            if err != nil {
+                t.Fatalf("open %s store: %v", backend, err)
+            }
+            t.Cleanup(func() { _ = store.Close() })
+            fn(t, store)
+        })
+    }
+}
+
+func putAll(t *testing.T, store Store, namespace string, keys ...string) {
+    t.Helper()
+    err := store.Update(func(tx StoreTx) error {
+        for _, key := range keys {
+            if err := tx.Put(namespace, []byte(key), []byte("v-"+key)); err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+    if err != nil {
+        t.Fatalf("put: %v", err)
+    }
+}
+
+func scanKeys(t *testing.T, store Store, namespace string, prefix []byte, reverse bool, limit int) []string {
+    t.Helper()
+    keys := make([]string, 0)
+    err := store.View(func(tx StoreTx) error {
+        return tx.Scan(namespace, prefix, reverse, func(k, v []byte) bool {
+            if string(v) != "v-"+string(k) {
+                t.Errorf("value of %q = %q", k, v)
+            }
+            keys = append(keys, string(k))
+            return limit <= 0 || len(keys) < limit
+        })
+    })
+    if err != nil {
+        t.Fatalf("scan: %v", err)
+    }
+    return keys
+}
+
+func TestStoreGetPutDelete(t *testing.T) {
+    forEachStore(t, func(t *testing.T, store Store) {
+        putAll(t, store, "ns", "a")
+        err := store.Update(func(tx StoreTx) error {
+            if err := tx.Put("ns", []byte("empty"), []byte{}); err != nil {
+                return err
+            }
+            return tx.Put("ns", []byte("binary"), []byte{0, 0xff, 1})
+        })
+        if err != nil {
+            t.Fatal(err)
+        }
+        _ = store.View(func(tx StoreTx) error {
+            if v, _ := tx.Get("ns", []byte("a")); string(v) != "v-a" {
+                t.Errorf("Get(a) = %q", v)
+            }
+            if v, _ := tx.Get("ns", []byte("empty")); v == nil || len(v) != 0 {
+                t.Errorf("Get(empty) = %#v, want empty non-nil", v)
+            }
+            if v, _ := tx.Get("ns", []byte("binary")); !reflect.DeepEqual(v, []byte{0, 0xff, 1}) {
+                t.Errorf("Get(binary) = %v", v)
+            }
+            if v, _ := tx.Get("ns", []byte("missing")); v != nil {
+                t.Errorf("Get(missing) = %q, want nil", v)
+            }
+            if v, _ := tx.Get("other", []byte("a")); v != nil {
+                t.Errorf("Get in missing namespace = %q, want nil", v)
+            }
+            return nil
+        })
+
+        err = store.Update(func(tx StoreTx) error {
+            if err := tx.Delete("ns", []byte("a")); err != nil {
+                return err
+            }
+            // 删除不存在的键和命名空间不报错
+            if err := tx.Delete("ns", []byte("missing")); err != nil {
+                return err
+            }
+            return tx.Delete("other", []byte("a"))
+        })
+        if err != nil {
+            t.Fatal(err)
+        }
+        _ = store.View(func(tx StoreTx) error {
+            if v, _ := tx.Get("ns", []byte("a")); v != nil {
+                t.Errorf("Get after Delete = %q", v)
+            }
+            return nil
+        })
+    })
+}
+
+func TestStoreScan(t *testing.T) {
+    forEachStore(t, func(t *testing.T, store Store) {
+        putAll(t, store, "ns", "a", "b/1", "b/2", "b/3", "c", "b\xff", "\xff\xff")
+        putAll(t, store, "other", "b/9")
+
+        tests := []struct {
+            name    string
+            prefix  string
+            reverse bool
+            limit   int
+            want    []string
+        }{
+            {name: "all", want: []string{"a", "b/1", "b/2", "b/3", "b\xff", "c", "\xff\xff"}},
+            {name: "all reverse", reverse: true, want: []string{"\xff\xff", "c", "b\xff", "b/3", "b/2", "b/1", "a"}},
+            {name: "prefix", prefix: "b/", want: []string{"b/1", "b/2", "b/3"}},
+            {name: "prefix reverse", prefix: "b/", reverse: true, want: []string{"b/3", "b/2", "b/1"}},
+            {name: "prefix stop", prefix: "b/", limit: 2, want: []string{"b/1", "b/2"}},
+            {name: "prefix reverse stop", prefix: "b/", reverse: true, limit: 2, want: []string{"b/3", "b/2"}},
+            {name: "prefix ending in 0xff", prefix: "b\xff", want: []string{"b\xff"}},
+            {name: "prefix of all 0xff", prefix: "\xff", reverse: true, want: []string{"\xff\xff"}},
+            {name: "prefix past the last key reverse", prefix: "d", reverse: true, want: []string{}},
+            {name: "no match", prefix: "x", want: []string{}},
+        }
+        for _, tt := range tests {
+            got := scanKeys(t, store, "ns", []byte(tt.prefix), tt.reverse, tt.limit)
+            if !reflect.DeepEqual(got, tt.want) {
+                t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+            }
+        }
+        if got := scanKeys(t, store, "missing", nil, false, 0); len(got) != 0 {
+            t.Errorf("scan missing namespace = %q", got)
+        }
+    })
+}
+
+// TestStoreScanManyKeys 超过 SQLite 每页的行数，检查分页边界
+func TestStoreScanManyKeys(t *testing.T) {
+    forEachStore(t, func(t *testing.T, store Store) {
+        var keys []string
+        for i := 0; i < sqliteScanPage*2+10; i++ {
+            keys = append(keys, fmt.Sprintf("k%04d", i))
+        }
+        putAll(t, store, "ns", keys...)
+
+        if got := scanKeys(t, store, "ns", []byte("k"), false, 0); !reflect.DeepEqual(got, keys) {
+            t.Errorf("forward scan returned %d keys, want %d", len(got), len(keys))
+        }
+        reversed := make([]string, 0, len(keys))
+        for i := len(keys) - 1; i >= 0; i-- {
+            reversed = append(reversed, keys[i])
+        }
+        if got := scanKeys(t, store, "ns", nil, true, 0); !reflect.DeepEqual(got, reversed) {
+            t.Errorf("reverse scan returned %d keys, want %d", len(got), len(reversed))
+        }
+    })
+}
+
+func TestStoreNamespaces(t *testing.T) {
+    forEachStore(t, func(t *testing.T, store Store) {
+        putAll(t, store, "b", "1", "2")
+        putAll(t, store, "a", "1")
+        _ = store.View(func(tx StoreTx) error {
+            names, err := tx.Namespaces()
+            if err != nil || !reflect.DeepEqual(names, []string{"a", "b"}) {
+                t.Errorf("Namespaces() = %q, %v", names, err)
+            }
+            if count, _ := tx.Count("b"); count != 2 {
+                t.Errorf("Count(b) = %d", count)
+            }
+            if count, _ := tx.Count("missing"); count != 0 {
+                t.Errorf("Count(missing) = %d", count)
+            }
+            return nil
+        })
+
+        err := store.Update(func(tx StoreTx) error {
+            if err := tx.DeleteNamespace("b"); err != nil {
+                return err
+            }
+            return tx.DeleteNamespace("missing")
+        })
+        if err != nil {
+            t.Fatal(err)
+        }
+        _ = store.View(func(tx StoreTx) error {
+            names, _ := tx.Namespaces()
+            if !reflect.DeepEqual(names, []string{"a"}) {
+                t.Errorf("Namespaces() after delete = %q", names)
+            }
+            return nil
+        })
+    })
+}
+
+func TestStoreSequence(t *testing.T) {
+    forEachStore(t, func(t *testing.T, store Store) {
+        err := store.Update(func(tx StoreTx) error {
+            for want := uint64(1); want <= 3; want++ {
+                seq, err := tx.NextSequence("log")
+                if err != nil {
+                    return err
+                }
+                if seq != want {
+                    t.Errorf("NextSequence() = %d, want %d", seq, want)
+                }
+            }
+            return tx.SetSequence("imported", 41)
+        })
+        if err != nil {
+            t.Fatal(err)
+        }
+        err = store.Update(func(tx StoreTx) error {
+            if seq, _ := tx.Sequence("log"); seq != 3 {
+                t.Errorf("Sequence(log) = %d", seq)
+            }
+            if seq, _ := tx.Sequence("missing"); seq != 0 {
+                t.Errorf("Sequence(missing) = %d", seq)
+            }
+            if seq, _ := tx.NextSequence("imported"); seq != 42 {
+                t.Errorf("NextSequence after SetSequence = %d", seq)
+            }
+            return nil
+        })
+        if err != nil {
+            t.Fatal(err)
+        }
+    })
+}
+
+func TestStoreRollback(t *testing.T) {
+    forEachStore(t, func(t *testing.T, store Store) {
+        putAll(t, store, "ns", "keep", "gone")
+        errAbort := errors.New("abort")
+        err := store.Update(func(tx StoreTx) error {
+            _ = tx.Put("ns", []byte("keep"), []byte("changed"))
+            _ = tx.Put("ns", []byte("new"), []byte("v-new"))
+            _ = tx.Delete("ns", []byte("gone"))
+            _ = tx.Put("created", []byte("k"), []byte("v"))
+            _, _ = tx.NextSequence("ns")
+            return errAbort
+        })
+        if !errors.Is(err, errAbort) {
+            t.Fatalf("Update() = %v, want %v", err, errAbort)
+        }
+        if got := scanKeys(t, store, "ns", nil, false, 0); !reflect.DeepEqual(got, []string{"gone", "keep"}) {
+            t.Errorf("keys after rollback = %q", got)
+        }
+        _ = store.View(func(tx StoreTx) error {
+            names, _ := tx.Namespaces()
+            if !reflect.DeepEqual(names, []string{"ns"}) {
+                t.Errorf("Namespaces() after rollback = %q", names)
+            }
+            if seq, _ := tx.Sequence("ns"); seq != 0 {
+                t.Errorf("Sequence() after rollback = %d", seq)
+            }
+            return nil
+        })
+    })
+}
+
+func TestStoreReadOnlyView(t *testing.T) {
+    forEachStore(t, func(t *testing.T, store Store) {
+        putAll(t, store, "ns", "a")
+        err := store.View(func(tx StoreTx) error {
+            return tx.Put("ns", []byte("b"), []byte("v"))
+        })
+        if err == nil {
+            t.Error("Put in View succeeded")
+        }
+        if got := scanKeys(t, store, "ns", nil, false, 0); !reflect.DeepEqual(got, []string{"a"}) {
+            t.Errorf("keys after write in View = %q", got)
+        }
+    })
+}
+
+func TestTypedBucketExpiry(t *testing.T) {
+    forEachStore(t, func(t *testing.T, store Store) {
+        cache := NewCache(store)
+        bucket := NewTypedBucket[int](cache, "ns")
+        if err := bucket.PutBatch(map[string]int{"a": 1, "b": 2}); err != nil {
+            t.Fatal(err)
+        }
+        if err := bucket.PutTTL("ttl", 3, time.Hour); err != nil {
+            t.Fatal(err)
+        }
+        // 把 b 的过期时间改到过去，读取时视为不存在
+        err := store.Update(func(tx StoreTx) error {
+            return setExpiry(tx, "ns", []byte("b"), time.Now().Add(-time.Minute).Unix())
+        })
+        if err != nil {
+            t.Fatal(err)
+        }
+        if _, found, _ := bucket.Get("b"); found {
+            t.Error("expired key found by Get")
+        }
+        if values, _ := bucket.Map(); !reflect.DeepEqual(values, map[string]int{"a": 1, "ttl": 3}) {
+            t.Errorf("Map() = %v", values)
+        }
+
+        evicted, err := cache.SweepExpired(time.Now())
+        if err != nil || !reflect.DeepEqual(evicted, map[string]int{"ns": 1}) {
+            t.Errorf("SweepExpired(now) = %v, %v", evicted, err)
+        }
+        evicted, err = cache.SweepExpired(time.Now().Add(2 * time.Hour))
+        if err != nil || !reflect.DeepEqual(evicted, map[string]int{"ns": 1}) {
+            t.Errorf("SweepExpired(+2h) = %v, %v", evicted, err)
+        }
+        if got := scanKeys(t, store, ExpiryIndexBucket, nil, false, 0); len(got) != 0 {
+            t.Errorf("expiry index not empty after sweep: %q", got)
+        }
+        _ = store.View(func(tx StoreTx) error {
+            if v, _ := tx.Get("ns", []byte("ttl")); v != nil {
+                t.Errorf("swept key still stored: %q", v)
+            }
+            if count, _ := tx.Count(ExpiryBucket); count != 0 {
+                t.Errorf("expiry bucket has %d keys after sweep", count)
+            }
+            return nil
+        })
+
+        // 不带 TTL 覆盖写入会清除原有的过期时间
+        if err = bucket.PutTTL("a", 1, time.Minute); err != nil {
+            t.Fatal(err)
+        }
+        if err = bucket.Put("a", 10); err != nil {
+            t.Fatal(err)
+        }
+        if evicted, _ = cache.SweepExpired(time.Now().Add(time.Hour)); len(evicted) != 0 {
+            t.Errorf("key rewritten without TTL was swept: %v", evicted)
+        }
+        if value, found, _ := bucket.Get("a"); !found || value != 10 {
+            t.Errorf("Get(a) = %d, %v", value, found)
+        }
+    })
+}
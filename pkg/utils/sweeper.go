@@ -0,0 +1,110 @@
+package utils
+
+import (
+    "log"
+    "sync"
+    "time"
+
+    "support-workflow/pkg/config"
+)
+
+const defaultSweepInterval = 10 * time.Minute
+
+// SweepStats 后台清理的统计，进程重启后清零
+type SweepStats struct {
+    Runs       int64            `json:"runs"`
+    Evicted    int64            `json:"evicted"`
+    Namespaces map[string]int64 `json:"namespaces"`
+    LastRunAt  int64            `json:"lastRunAt"`
+    // LastEvicted、LastDuration 最近一次清理删除的键数和耗时（毫秒）
+    LastEvicted  int    `json:"lastEvicted"`
+    LastDuration int64  `json:"lastDuration"`
+    LastError    string `json:"lastError,omitempty"`
+}
+
+// Sweeper 定期删除缓存中的过期键，间隔读取 CACHE_SWEEP_INTERVAL，修改后在下一次清理时生效
+type Sweeper struct {
+    cache *Cache
+    mu    sync.Mutex
+    stats SweepStats
+    stop  chan struct{}
+    // done 后台清理退出后关闭，Stop 等待正在进行的清理结束
+    done chan struct{}
+}
+
+func NewSweeper(cache *Cache) *Sweeper {
+    return &Sweeper{cache: cache, stats: SweepStats{Namespaces: make(map[string]int64)}, stop: make(chan struct{})}
+}
+
+func sweepInterval() time.Duration {
+    interval, err := time.ParseDuration(config.GetConf().CacheSweepInterval)
+    if err != nil || interval <= 0 {
+        return defaultSweepInterval
+    }
+    return interval
+}
+
+func (s *Sweeper) Start() {
+    s.done = make(chan struct{})
+    go func() {
+        defer close(s.done)
+        for {
+            timer := time.NewTimer(sweepInterval())
+            select {
+            case <-s.stop:
+                timer.Stop()
+                return
+            case <-timer.C:
+            }
+            if _, err := s.Sweep(); err != nil {
+                log.Printf("Sweep expired cache keys failed: %v", err)
+            }
+        }
+    }()
+}
+
+// Stop 停止后台清理并等待正在进行的清理结束，之后才能关闭缓存
+func (s *Sweeper) Stop() {
+    close(s.stop)
+    if s.done != nil {
+        <-s.done
+    }
+}
+
+// Sweep 立即清理一次，返回删除的键数
+func (s *Sweeper) Sweep() (int, error) {
+    start := time.Now()
+    evicted, err := s.cache.SweepExpired(start)
+
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    total := 0
+    for namespace, count := range evicted {
+        s.stats.Namespaces[namespace] += int64(count)
+        total += count
+    }
+    s.stats.Runs += 1
+    s.stats.Evicted += int64(total)
+    s.stats.LastRunAt = start.Unix()
+    s.stats.LastEvicted = total
+    s.stats.LastDuration = time.Since(start).Milliseconds()
+    s.stats.LastError = ""
+    if err != nil {
+        s.stats.LastError = err.Error()
+    }
+    if total > 0 {
+        log.Printf("Swept %d expired cache keys: %v", total, evicted)
+    }
+    return total, err
+}
+
+func (s *Sweeper) Stats() SweepStats {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    stats := s.stats
+    stats.Namespaces = make(map[string]int64, len(s.stats.Namespaces))
+    for namespace, count := range s.stats.Namespaces {
+        stats.Namespaces[namespace] = count
+    }
+    return stats
+}
@@ -0,0 +1,183 @@
+package utils
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "time"
+)
+
+// Entry 存储桶中的一个键值
+type Entry[T any] struct {
+    Key   string `json:"key"`
+    Value T      `json:"value"`
+}
+
+// TypedBucket 按类型读写一个存储桶，一个存储桶即一个命名空间，值直接以 JSON 保存
+type TypedBucket[T any] struct {
+    cache *Cache
+    name  string
+}
+
+func NewTypedBucket[T any](cache *Cache, namespace string) *TypedBucket[T] {
+    return &TypedBucket[T]{cache: cache, name: namespace}
+}
+
+func (b *TypedBucket[T]) Namespace() string {
+    return b.name
+}
+
+func (b *TypedBucket[T]) decode(key, data []byte) (T, error) {
+    var value T
+    if err := json.Unmarshal(data, &value); err != nil {
+        return value, fmt.Errorf("%s/%s 反序列化失败: %w", b.name, key, err)
+    }
+    return value, nil
+}
+
+// Get 读取一个键，键不存在或已过期时返回 false，与零值区分开；过期的键由 Sweeper 在后台删除
+func (b *TypedBucket[T]) Get(key string) (T, bool, error) {
+    var value T
+    found := false
+    now := time.Now().Unix()
+    err := b.cache.store.View(func(tx StoreTx) error {
+        data, err := tx.Get(b.name, []byte(key))
+        if err != nil || data == nil || expired(tx, b.name, []byte(key), now) {
+            return err
+        }
+        found = true
+        value, err = b.decode([]byte(key), data)
+        return err
+    })
+    return value, found, err
+}
+
+// GetBatch 在一个事务中读取多个键，结果只包含存在的键
+func (b *TypedBucket[T]) GetBatch(keys []string) (map[string]T, error) {
+    values := make(map[string]T, len(keys))
+    now := time.Now().Unix()
+    err := b.cache.store.View(func(tx StoreTx) error {
+        for _, key := range keys {
+            data, err := tx.Get(b.name, []byte(key))
+            if err != nil {
+                return err
+            }
+            if data == nil || expired(tx, b.name, []byte(key), now) {
+                continue
+            }
+            value, err := b.decode([]byte(key), data)
+            if err != nil {
+                return err
+            }
+            values[key] = value
+        }
+        return nil
+    })
+    return values, err
+}
+
+func (b *TypedBucket[T]) Put(key string, value T) error {
+    return b.PutBatchTTL(map[string]T{key: value}, 0)
+}
+
+// PutTTL 写入一个键，ttl 后过期；ttl 为 0 时不过期
+func (b *TypedBucket[T]) PutTTL(key string, value T, ttl time.Duration) error {
+    return b.PutBatchTTL(map[string]T{key: value}, ttl)
+}
+
+// PutBatch 在一个事务中写入多个键，任一失败时全部不写入；覆盖的键原有的过期时间会被清除
+func (b *TypedBucket[T]) PutBatch(values map[string]T) error {
+    return b.PutBatchTTL(values, 0)
+}
+
+// PutBatchTTL 同 PutBatch，写入的键在 ttl 后过期
+func (b *TypedBucket[T]) PutBatchTTL(values map[string]T, ttl time.Duration) error {
+    if len(values) == 0 {
+        return nil
+    }
+    encoded := make(map[string][]byte, len(values))
+    for key, value := range values {
+        data, err := json.Marshal(value)
+        if err != nil {
+            return fmt.Errorf("%s/%s 序列化失败: %w", b.name, key, err)
+        }
+        encoded[key] = data
+    }
+    var at int64
+    if ttl > 0 {
+        at = time.Now().Add(ttl).Unix()
+    }
+    return b.cache.store.Update(func(tx StoreTx) error {
+        for key, data := range encoded {
+            if err := tx.Put(b.name, []byte(key), data); err != nil {
+                return err
+            }
+            if err := setExpiry(tx, b.name, []byte(key), at); err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+}
+
+func (b *TypedBucket[T]) Delete(key string) error {
+    return b.DeleteBatch([]string{key})
+}
+
+// DeleteBatch 在一个事务中删除多个键，不存在的键忽略
+func (b *TypedBucket[T]) DeleteBatch(keys []string) error {
+    if len(keys) == 0 {
+        return nil
+    }
+    return b.cache.store.Update(func(tx StoreTx) error {
+        for _, key := range keys {
+            if err := tx.Delete(b.name, []byte(key)); err != nil {
+                return err
+            }
+            if err := setExpiry(tx, b.name, []byte(key), 0); err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+}
+
+// List 按键排序返回全部键值
+func (b *TypedBucket[T]) List() ([]Entry[T], error) {
+    return b.Scan("")
+}
+
+// Scan 按键排序返回以 prefix 开头且未过期的键值
+func (b *TypedBucket[T]) Scan(prefix string) ([]Entry[T], error) {
+    entries := make([]Entry[T], 0)
+    now := time.Now().Unix()
+    err := b.cache.store.View(func(tx StoreTx) error {
+        var err error
+        scanErr := tx.Scan(b.name, []byte(prefix), false, func(k, v []byte) bool {
+            if expired(tx, b.name, k, now) {
+                return true
+            }
+            var value T
+            if value, err = b.decode(k, v); err != nil {
+                return false
+            }
+            entries = append(entries, Entry[T]{Key: string(k), Value: value})
+            return true
+        })
+        return errors.Join(scanErr, err)
+    })
+    return entries, err
+}
+
+// Map 以映射形式返回全部键值
+func (b *TypedBucket[T]) Map() (map[string]T, error) {
+    entries, err := b.List()
+    if err != nil {
+        return nil, err
+    }
+    values := make(map[string]T, len(entries))
+    for _, entry := range entries {
+        values[entry.Key] = entry.Value
+    }
+    return values, nil
+}
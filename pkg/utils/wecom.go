@@ -0,0 +1,159 @@
+package utils
+
+// 企业微信群机器人消息，参考 https://developer.work.weixin.qq.com/document/path/91770
+
+const (
+    WecomMsgTypeText         = "text"
+    WecomMsgTypeMarkdown     = "markdown"
+    WecomMsgTypeNews         = "news"
+    WecomMsgTypeTemplateCard = "template_card"
+)
+
+type WecomText struct {
+    Content             string   `json:"content"`
+    MentionedMobileList []string `json:"mentioned_mobile_list,omitempty"`
+}
+
+// WecomMarkdown markdown 消息不支持按手机号 @，需要 @ 时另发一条文本消息
+type WecomMarkdown struct {
+    Content string `json:"content"`
+}
+
+type WecomArticle struct {
+    Title       string `json:"title"`
+    Description string `json:"description,omitempty"`
+    URL         string `json:"url"`
+    PicURL      string `json:"picurl,omitempty"`
+}
+
+type WecomNews struct {
+    Articles []WecomArticle `json:"articles"`
+}
+
+type WecomCardTitle struct {
+    Title string `json:"title,omitempty"`
+    Desc  string `json:"desc,omitempty"`
+}
+
+type WecomCardField struct {
+    KeyName string `json:"keyname"`
+    Value   string `json:"value,omitempty"`
+    Type    int    `json:"type,omitempty"`
+    URL     string `json:"url,omitempty"`
+}
+
+type WecomCardJump struct {
+    Type  int    `json:"type"`
+    Title string `json:"title"`
+    URL   string `json:"url,omitempty"`
+}
+
+type WecomCardAction struct {
+    Type int    `json:"type"`
+    URL  string `json:"url,omitempty"`
+}
+
+type WecomTemplateCard struct {
+    CardType              string           `json:"card_type"`
+    MainTitle             WecomCardTitle   `json:"main_title"`
+    EmphasisContent       *WecomCardTitle  `json:"emphasis_content,omitempty"`
+    SubTitleText          string           `json:"sub_title_text,omitempty"`
+    HorizontalContentList []WecomCardField `json:"horizontal_content_list,omitempty"`
+    JumpList              []WecomCardJump  `json:"jump_list,omitempty"`
+    CardAction            WecomCardAction  `json:"card_action"`
+}
+
+type WecomMessage struct {
+    MsgType      string             `json:"msgtype"`
+    Text         *WecomText         `json:"text,omitempty"`
+    Markdown     *WecomMarkdown     `json:"markdown,omitempty"`
+    News         *WecomNews         `json:"news,omitempty"`
+    TemplateCard *WecomTemplateCard `json:"template_card,omitempty"`
+}
+
+func NewWecomText(content string, mobiles ...string) *WecomMessage {
+    return &WecomMessage{
+        MsgType: WecomMsgTypeText,
+        Text:    &WecomText{Content: content, MentionedMobileList: mobiles},
+    }
+}
+
+func NewWecomMarkdown(content string) *WecomMessage {
+    return &WecomMessage{
+        MsgType:  WecomMsgTypeMarkdown,
+        Markdown: &WecomMarkdown{Content: content},
+    }
+}
+
+func NewWecomNews(articles ...WecomArticle) *WecomMessage {
+    return &WecomMessage{
+        MsgType: WecomMsgTypeNews,
+        News:    &WecomNews{Articles: articles},
+    }
+}
+
+// WecomTextNoticeBuilder 构造文本通知型模板卡片
+type WecomTextNoticeBuilder struct {
+    card WecomTemplateCard
+}
+
+func NewWecomTextNotice(title, desc string) *WecomTextNoticeBuilder {
+    return &WecomTextNoticeBuilder{
+        card: WecomTemplateCard{
+            CardType:   "text_notice",
+            MainTitle:  WecomCardTitle{Title: title, Desc: desc},
+            CardAction: WecomCardAction{Type: 1},
+        },
+    }
+}
+
+func (b *WecomTextNoticeBuilder) Emphasis(title, desc string) *WecomTextNoticeBuilder {
+    b.card.EmphasisContent = &WecomCardTitle{Title: title, Desc: desc}
+    return b
+}
+
+func (b *WecomTextNoticeBuilder) SubTitle(text string) *WecomTextNoticeBuilder {
+    b.card.SubTitleText = text
+    return b
+}
+
+// Field 卡片最多展示 6 个键值对，超出的会被企业微信忽略
+func (b *WecomTextNoticeBuilder) Field(key, value string) *WecomTextNoticeBuilder {
+    b.card.HorizontalContentList = append(b.card.HorizontalContentList, WecomCardField{KeyName: key, Value: value})
+    return b
+}
+
+func (b *WecomTextNoticeBuilder) Link(title, url string) *WecomTextNoticeBuilder {
+    if url == "" {
+        return b
+    }
+    b.card.JumpList = append(b.card.JumpList, WecomCardJump{Type: 1, Title: title, URL: url})
+    b.card.CardAction.URL = url
+    return b
+}
+
+// Build 模板卡片必须有点击跳转，没有链接时退化为 markdown 消息
+func (b *WecomTextNoticeBuilder) Build() *WecomMessage {
+    if b.card.CardAction.URL == "" {
+        return NewWecomMarkdown(b.markdown())
+    }
+    card := b.card
+    return &WecomMessage{MsgType: WecomMsgTypeTemplateCard, TemplateCard: &card}
+}
+
+func (b *WecomTextNoticeBuilder) markdown() string {
+    content := "**" + b.card.MainTitle.Title + "**"
+    if b.card.MainTitle.Desc != "" {
+        content += "\n" + `<font color="comment">` + b.card.MainTitle.Desc + "</font>"
+    }
+    if b.card.EmphasisContent != nil {
+        content += "\n> " + b.card.EmphasisContent.Desc + ": **" + b.card.EmphasisContent.Title + "**"
+    }
+    for _, field := range b.card.HorizontalContentList {
+        content += "\n> " + field.KeyName + ": " + field.Value
+    }
+    if b.card.SubTitleText != "" {
+        content += "\n" + b.card.SubTitleText
+    }
+    return content
+}
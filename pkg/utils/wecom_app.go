@@ -0,0 +1,120 @@
+package utils
+
+import (
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/url"
+    "sync"
+    "time"
+
+    "support-workflow/pkg/config"
+)
+
+// 企业微信自建应用接口，群聊会话参考 https://developer.work.weixin.qq.com/document/path/90245
+
+const wecomAPIEndpoint = "https://qyapi.weixin.qq.com/cgi-bin"
+
+type wecomAPIResponse struct {
+    ErrCode     int    `json:"errcode"`
+    ErrMsg      string `json:"errmsg"`
+    AccessToken string `json:"access_token"`
+    ExpiresIn   int64  `json:"expires_in"`
+    UserID      string `json:"userid"`
+    ChatID      string `json:"chatid"`
+}
+
+func (r *wecomAPIResponse) err() error {
+    if r.ErrCode != 0 {
+        return fmt.Errorf("wecom error %d: %s", r.ErrCode, r.ErrMsg)
+    }
+    return nil
+}
+
+type WecomAppClient struct {
+    client *Client
+    corpID string
+    secret string
+
+    mu        sync.Mutex
+    token     string
+    expiresAt time.Time
+}
+
+func NewWecomAppClient() *WecomAppClient {
+    conf := config.GetConf()
+    return &WecomAppClient{
+        client: NewClient(wecomAPIEndpoint),
+        corpID: conf.WecomCorpID,
+        secret: conf.WecomCorpSecret.Reveal(),
+    }
+}
+
+// accessToken 有效期 2 小时，提前 5 分钟刷新
+func (c *WecomAppClient) accessToken() (string, error) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if c.token != "" && time.Now().Before(c.expiresAt) {
+        return c.token, nil
+    }
+    if c.corpID == "" || c.secret == "" {
+        return "", fmt.Errorf("WECOM_CORP_ID and WECOM_CORP_SECRET are required")
+    }
+    var resp wecomAPIResponse
+    path := fmt.Sprintf("/gettoken?corpid=%s&corpsecret=%s", url.QueryEscape(c.corpID), url.QueryEscape(c.secret))
+    if err := c.client.Get(path, &resp); err != nil {
+        return "", err
+    }
+    if err := resp.err(); err != nil {
+        return "", err
+    }
+    c.token = resp.AccessToken
+    c.expiresAt = time.Now().Add(time.Duration(resp.ExpiresIn)*time.Second - 5*time.Minute)
+    return c.token, nil
+}
+
+func (c *WecomAppClient) post(path string, body interface{}) (*wecomAPIResponse, error) {
+    token, err := c.accessToken()
+    if err != nil {
+        return nil, err
+    }
+    resp, err := c.client.Post(fmt.Sprintf("%s?access_token=%s", path, url.QueryEscape(token)), body)
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+    data, err := io.ReadAll(resp.Body)
+    if err != nil {
+        return nil, err
+    }
+    var apiResp wecomAPIResponse
+    if err = json.Unmarshal(data, &apiResp); err != nil {
+        return nil, fmt.Errorf("解析企业微信响应失败: %w", err)
+    }
+    return &apiResp, apiResp.err()
+}
+
+// GetUserIDByMobile 通过手机号获取成员 userid
+func (c *WecomAppClient) GetUserIDByMobile(mobile string) (string, error) {
+    resp, err := c.post("/user/getuserid", map[string]string{"mobile": mobile})
+    if err != nil {
+        return "", fmt.Errorf("get wecom user of %s failed: %w", mobile, err)
+    }
+    return resp.UserID, nil
+}
+
+// CreateAppChat 创建群聊会话，群成员至少 2 人，owner 为空时由企业微信从成员中随机指定
+func (c *WecomAppClient) CreateAppChat(name, owner string, userIDs []string) (string, error) {
+    if len(userIDs) < 2 {
+        return "", fmt.Errorf("wecom chat requires at least 2 members, got %d", len(userIDs))
+    }
+    body := map[string]interface{}{"name": name, "userlist": userIDs}
+    if owner != "" {
+        body["owner"] = owner
+    }
+    resp, err := c.post("/appchat/create", body)
+    if err != nil {
+        return "", fmt.Errorf("create wecom chat %s failed: %w", name, err)
+    }
+    return resp.ChatID, nil
+}
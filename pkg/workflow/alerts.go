@@ -0,0 +1,266 @@
+package workflow
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"support-workflow/pkg/config"
+	"support-workflow/pkg/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// TaskHealthNamespace 任务健康状态的存储桶，键为任务名称
+	TaskHealthNamespace = "task-health"
+
+	AlertKindFailure = "failure"
+	AlertKindStale   = "stale"
+)
+
+// healthMu 多个任务并发执行，读写健康状态时串行
+var healthMu sync.Mutex
+
+// TaskHealth 任务最近的执行结果及告警状态
+type TaskHealth struct {
+	ConsecutiveFailures int    `json:"consecutiveFailures"`
+	LastSuccess         int64  `json:"lastSuccess"`
+	LastFailure         int64  `json:"lastFailure"`
+	LastError           string `json:"lastError"`
+	// WatchedSince 本次进程开始观察的时间，停机期间没有执行不算超时
+	WatchedSince int64 `json:"watchedSince"`
+	// Alert 当前未恢复的告警，相同告警在 ALERT_REPEAT_INTERVAL 内只发一次
+	Alert *TaskAlert `json:"alert,omitempty"`
+}
+
+type TaskAlert struct {
+	Kind      string `json:"kind"`
+	Message   string `json:"message"`
+	FirstAt   int64  `json:"firstAt"`
+	SentAt    int64  `json:"sentAt"`
+	Escalated bool   `json:"escalated"`
+}
+
+func parseAlertDuration(name, value string, fallback time.Duration) time.Duration {
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		log.Printf("Invalid %s %q, use %s", name, value, fallback)
+		return fallback
+	}
+	return duration
+}
+
+// staleWindow 超过该时间没有成功执行视为异常，至少为任务执行间隔的两倍
+func staleWindow(name string) time.Duration {
+	window := parseAlertDuration("ALERT_STALE_AFTER", config.GetConf().AlertStaleAfter, 30*time.Minute)
+	interval := taskInterval(name)
+	if window < 2*interval {
+		window = 2 * interval
+	}
+	return window
+}
+
+func taskHealthBucket(cache *utils.Cache) *utils.TypedBucket[*TaskHealth] {
+	return utils.NewTypedBucket[*TaskHealth](cache, TaskHealthNamespace)
+}
+
+func loadTaskHealth(cache *utils.Cache) (map[string]*TaskHealth, error) {
+	return taskHealthBucket(cache).Map()
+}
+
+func saveTaskHealth(cache *utils.Cache, health map[string]*TaskHealth) error {
+	return taskHealthBucket(cache).PutBatch(health)
+}
+
+// updateTaskHealth 加锁读取、修改并保存健康状态
+func (tm *TaskManager) updateTaskHealth(fn func(health map[string]*TaskHealth)) {
+	healthMu.Lock()
+	defer healthMu.Unlock()
+	health, err := loadTaskHealth(tm.Cache)
+	if err != nil {
+		log.Printf("Load task health failed: %v", err)
+		return
+	}
+	fn(health)
+	if err = saveTaskHealth(tm.Cache, health); err != nil {
+		log.Printf("Save task health failed: %v", err)
+	}
+}
+
+// recordHealth 记录一次执行结果：连续失败达到阈值时告警，恢复成功后发送恢复通知
+func (tm *TaskManager) recordHealth(run *TaskRun, err error) {
+	if run.DryRun {
+		return
+	}
+	now := time.Now()
+	tm.updateTaskHealth(func(health map[string]*TaskHealth) {
+		state := taskHealth(health, run.Task, now)
+		if err == nil {
+			if state.Alert != nil {
+				tm.sendRecovery(run.Task, state, now)
+			}
+			state.ConsecutiveFailures = 0
+			state.LastSuccess = now.Unix()
+			state.Alert = nil
+			return
+		}
+		state.ConsecutiveFailures += 1
+		state.LastFailure = now.Unix()
+		state.LastError = err.Error()
+		if state.ConsecutiveFailures >= config.GetConf().AlertFailureThreshold {
+			message := fmt.Sprintf("连续失败 %d 次，最近错误: %s", state.ConsecutiveFailures, state.LastError)
+			tm.raiseAlert(run.Task, state, AlertKindFailure, message, now)
+		}
+	})
+}
+
+// checkStaleTasks 定时检查长时间没有成功执行的任务，覆盖任务卡住或定时器未执行的情况
+func (tm *TaskManager) checkStaleTasks(now time.Time) {
+	tm.updateTaskHealth(func(health map[string]*TaskHealth) {
+		for _, name := range TaskNames {
+			state := taskHealth(health, name, now)
+			since := state.LastSuccess
+			if state.WatchedSince > since {
+				since = state.WatchedSince
+			}
+			window := staleWindow(name)
+			if now.Sub(time.Unix(since, 0)) < window {
+				continue
+			}
+			// 连续失败的告警已在进行中时不再叠加超时告警
+			if state.Alert != nil && state.Alert.Kind == AlertKindFailure {
+				continue
+			}
+			message := fmt.Sprintf("超过 %s 没有成功执行", window)
+			if state.LastError != "" {
+				message += "，最近错误: " + state.LastError
+			}
+			tm.raiseAlert(name, state, AlertKindStale, message, now)
+		}
+	})
+}
+
+// watchTaskHealth 启动时重置观察起点，之后每分钟检查一次
+func (tm *TaskManager) watchTaskHealth() {
+	now := time.Now()
+	tm.updateTaskHealth(func(health map[string]*TaskHealth) {
+		for _, name := range TaskNames {
+			taskHealth(health, name, now).WatchedSince = now.Unix()
+		}
+	})
+	ticker := time.NewTicker(1 * time.Minute)
+	tm.tickers = append(tm.tickers, ticker)
+	go func() {
+		for now := range ticker.C {
+			tm.checkStaleTasks(now)
+		}
+	}()
+}
+
+func taskHealth(health map[string]*TaskHealth, name string, now time.Time) *TaskHealth {
+	state, exists := health[name]
+	if !exists {
+		state = &TaskHealth{WatchedSince: now.Unix()}
+		health[name] = state
+	}
+	return state
+}
+
+// raiseAlert 相同告警在重复间隔内不再发送；持续时间超过升级时间或失败次数达到升级阈值时 @ 负责人
+func (tm *TaskManager) raiseAlert(name string, state *TaskHealth, kind, message string, now time.Time) {
+	conf := config.GetConf()
+	alert := state.Alert
+	if alert == nil || alert.Kind != kind {
+		alert = &TaskAlert{Kind: kind, FirstAt: now.Unix()}
+		state.Alert = alert
+	}
+	escalate := !alert.Escalated &&
+		(state.ConsecutiveFailures >= conf.AlertEscalationFailures ||
+			now.Sub(time.Unix(alert.FirstAt, 0)) >= parseAlertDuration("ALERT_ESCALATE_AFTER", conf.AlertEscalateAfter, 2*time.Hour))
+	repeat := parseAlertDuration("ALERT_REPEAT_INTERVAL", conf.AlertRepeatInterval, 6*time.Hour)
+	sameMessage := alertSignature(alert.Message) == alertSignature(message)
+	if alert.SentAt != 0 && sameMessage && !escalate && now.Sub(time.Unix(alert.SentAt, 0)) < repeat {
+		return
+	}
+	alert.Message = message
+
+	msg := utils.Message{
+		Title:   fmt.Sprintf("【告警】%s", taskTitles[name]),
+		Content: message,
+		Fields: []utils.MessageField{
+			{Key: "任务", Value: name},
+			{Key: "开始时间", Value: time.Unix(alert.FirstAt, 0).Format("2006-01-02 15:04:05")},
+		},
+	}
+	if escalate {
+		msg.Title = fmt.Sprintf("【告警升级】%s", taskTitles[name])
+		msg.MentionMobiles = remindMobiles()
+	}
+	msg.Wecom = utils.NewWecomMarkdown(fmt.Sprintf(
+		"**%s**\n> <font color=\"warning\">%s</font>\n> 开始时间: %s",
+		msg.Title, message, time.Unix(alert.FirstAt, 0).Format("2006-01-02 15:04:05"),
+	))
+	if err := tm.Outbox.Enqueue(utils.EventAlert, msg); err != nil {
+		log.Printf("Send alert of %s failed: %v", name, err)
+		return
+	}
+	alert.SentAt = now.Unix()
+	if escalate {
+		alert.Escalated = true
+	}
+}
+
+// alertSignature 告警内容中的失败次数每次都会变化，去重时忽略数字
+func alertSignature(message string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return -1
+		}
+		return r
+	}, message)
+}
+
+func (tm *TaskManager) sendRecovery(name string, state *TaskHealth, now time.Time) {
+	if state.Alert.SentAt == 0 {
+		return
+	}
+	duration := now.Sub(time.Unix(state.Alert.FirstAt, 0)).Round(time.Minute)
+	msg := utils.Message{
+		Title:   fmt.Sprintf("【恢复】%s", taskTitles[name]),
+		Content: fmt.Sprintf("任务已恢复正常，异常持续 %s", duration),
+	}
+	if state.Alert.Escalated {
+		msg.MentionMobiles = remindMobiles()
+	}
+	msg.Wecom = utils.NewWecomMarkdown(fmt.Sprintf(
+		"**%s**\n> <font color=\"info\">%s</font>", msg.Title, msg.Content,
+	))
+	if err := tm.Outbox.Enqueue(utils.EventAlert, msg); err != nil {
+		log.Printf("Send recovery of %s failed: %v", name, err)
+	}
+}
+
+func remindMobiles() []string {
+	var mobiles []string
+	for _, mobile := range strings.Split(config.GetConf().RobotRemindsMobilePhones, ",") {
+		if mobile = strings.TrimSpace(mobile); mobile != "" {
+			mobiles = append(mobiles, mobile)
+		}
+	}
+	return mobiles
+}
+
+func (tm *TaskManager) listTaskHealth(c *gin.Context) {
+	healthMu.Lock()
+	health, err := loadTaskHealth(tm.Cache)
+	healthMu.Unlock()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": health})
+}
@@ -0,0 +1,104 @@
+package workflow
+
+import (
+	"net/http"
+	"strings"
+
+	"support-workflow/pkg/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (tm *TaskManager) cacheStats(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"data": tm.Sweeper.Stats()})
+}
+
+// sweepCache 立即清理过期键，不等待下一次后台清理
+func (tm *TaskManager) sweepCache(c *gin.Context) {
+	evicted, err := tm.Sweeper.Sweep()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"evicted": evicted, "data": tm.Sweeper.Stats()})
+}
+
+func (tm *TaskManager) listCacheNamespaces(c *gin.Context) {
+	namespaces, err := tm.Cache.Namespaces()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": namespaces, "total": len(namespaces)})
+}
+
+func (tm *TaskManager) listCacheKeys(c *gin.Context) {
+	keys, err := tm.Cache.Keys(c.Param("namespace"), c.Query("prefix"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": keys, "total": len(keys)})
+}
+
+// cacheKey 键可能包含 /，路由使用 *key，自增序号键写成 #N（URL 中为 %23N）
+func cacheKey(c *gin.Context) []byte {
+	return utils.ParseKey(strings.TrimPrefix(c.Param("key"), "/"))
+}
+
+func (tm *TaskManager) showCacheKey(c *gin.Context) {
+	info, value, err := tm.Cache.Lookup(c.Param("namespace"), cacheKey(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if info == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "key not found"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": info, "value": value})
+}
+
+func (tm *TaskManager) deleteCacheKey(c *gin.Context) {
+	namespace, key := c.Param("namespace"), cacheKey(c)
+	info, _, err := tm.Cache.Lookup(namespace, key)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if info == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "key not found"})
+		return
+	}
+	if err = tm.Cache.DeleteEntry(namespace, key); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "删除成功"})
+}
+
+// exportCache 导出整个缓存，服务运行时可用于备份
+func (tm *TaskManager) exportCache(c *gin.Context) {
+	snapshot, err := tm.Cache.Export()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Header("Content-Disposition", `attachment; filename="cache.json"`)
+	c.JSON(http.StatusOK, snapshot)
+}
+
+// importCache 导入 exportCache 的结果，replace=true 时先清空缓存
+func (tm *TaskManager) importCache(c *gin.Context) {
+	snapshot := utils.Snapshot{}
+	if err := c.ShouldBindJSON(&snapshot); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	imported, err := tm.Cache.Import(&snapshot, c.Query("replace") == "true")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "导入成功", "imported": imported})
+}
@@ -0,0 +1,131 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"support-workflow/pkg/config"
+	"support-workflow/pkg/utils"
+
+	"github.com/gin-gonic/gin"
+	larkcore "github.com/larksuite/oapi-sdk-go/v3/core"
+	larkbitable "github.com/larksuite/oapi-sdk-go/v3/service/bitable/v1"
+)
+
+const (
+	ChangeLogBucket = "change-log"
+
+	ChangeSourceSupport = "support"
+	ChangeSourceWebForm = "web"
+
+	ChangeActionCreate = "create"
+	ChangeActionUpdate = "update"
+)
+
+// ChangeLogEntry 对飞书客户行的一次写入，只追加不修改
+type ChangeLogEntry struct {
+	ID       uint64    `json:"id"`
+	Time     int64     `json:"time"`
+	RecordID string    `json:"recordId"`
+	Company  string    `json:"company"`
+	Action   string    `json:"action"`
+	Changes  ChangeSet `json:"changes"`
+	Task     string    `json:"task"`
+	RunID    string    `json:"runId"`
+	Source   string    `json:"source"`
+}
+
+func recordChange(cache *utils.Cache, entry ChangeLogEntry) {
+	if len(entry.Changes) == 0 {
+		return
+	}
+	entry.Time = time.Now().UnixMilli()
+	var err error
+	if entry.ID, err = cache.Append(ChangeLogBucket, entry); err != nil {
+		log.Printf("Save change log of %s failed: %v", entry.Company, err)
+	}
+	if err = mirrorChangeToFeishu(entry); err != nil {
+		log.Printf("Mirror change log of %s to feishu failed: %v", entry.Company, err)
+	}
+}
+
+// mirrorChangeToFeishu 配置了 FEISHU_CHANGELOG_TABLE_ID 时，每个字段变更写一行到飞书「变更日志」表
+func mirrorChangeToFeishu(entry ChangeLogEntry) error {
+	conf := config.GetConf()
+	if conf.FeishuChangeLogTableID == "" {
+		return nil
+	}
+	var records []*larkbitable.AppTableRecord
+	for _, change := range entry.Changes {
+		records = append(records, larkbitable.NewAppTableRecordBuilder().
+			Fields(map[string]interface{}{
+				`时间`:   entry.Time,
+				`客户`:   entry.Company,
+				`记录ID`: entry.RecordID,
+				`操作`:   entry.Action,
+				`字段`:   change.Field,
+				`旧值`:   change.Old,
+				`新值`:   change.New,
+				`任务`:   entry.Task,
+				`运行ID`: entry.RunID,
+				`来源`:   entry.Source,
+			}).Build())
+	}
+
+	client := utils.NewFeishuClient()
+	req := larkbitable.NewBatchCreateAppTableRecordReqBuilder().
+		AppToken(conf.FeishuTableAppToken).
+		TableId(conf.FeishuChangeLogTableID).
+		Body(larkbitable.NewBatchCreateAppTableRecordReqBodyBuilder().Records(records).Build()).
+		Build()
+	resp, err := client.Client.Bitable.V1.AppTableRecord.BatchCreate(context.Background(), req)
+	if err != nil {
+		return err
+	}
+	if !resp.Success() {
+		return fmt.Errorf("error response: %s", larkcore.Prettify(resp.CodeError))
+	}
+	return nil
+}
+
+func queryChanges(cache *utils.Cache, company, recordID string, limit int) ([]ChangeLogEntry, error) {
+	entries := make([]ChangeLogEntry, 0)
+	var decodeErr error
+	err := cache.Iterate(ChangeLogBucket, true, func(key, value []byte) bool {
+		var entry ChangeLogEntry
+		if decodeErr = json.Unmarshal(value, &entry); decodeErr != nil {
+			return false
+		}
+		if company != "" && entry.Company != company {
+			return true
+		}
+		if recordID != "" && entry.RecordID != recordID {
+			return true
+		}
+		entries = append(entries, entry)
+		return len(entries) < limit
+	})
+	if err == nil {
+		err = decodeErr
+	}
+	return entries, err
+}
+
+func (tm *TaskManager) listChanges(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+		return
+	}
+	entries, err := queryChanges(tm.Cache, c.Query("company"), c.Query("record_id"), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": entries, "total": len(entries)})
+}
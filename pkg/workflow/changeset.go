@@ -0,0 +1,99 @@
+package workflow
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// FieldChange 单个飞书字段的变更，新旧值统一为文本便于比较和记录
+type FieldChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+type ChangeSet []FieldChange
+
+func (cs ChangeSet) Fields() []string {
+	fields := make([]string, 0, len(cs))
+	for _, change := range cs {
+		fields = append(fields, change.Field)
+	}
+	return fields
+}
+
+// Values 从完整的字段映射中取出发生变更的字段，只更新这些列
+func (cs ChangeSet) Values(fields map[string]interface{}) map[string]interface{} {
+	values := make(map[string]interface{}, len(cs))
+	for _, change := range cs {
+		values[change.Field] = fields[change.Field]
+	}
+	return values
+}
+
+func (cs ChangeSet) NewValues() map[string]string {
+	values := make(map[string]string, len(cs))
+	for _, change := range cs {
+		values[change.Field] = change.New
+	}
+	return values
+}
+
+func (cs ChangeSet) String() string {
+	items := make([]string, 0, len(cs))
+	for _, change := range cs {
+		items = append(items, fmt.Sprintf("%s: %q -> %q", change.Field, change.Old, change.New))
+	}
+	return strings.Join(items, "; ")
+}
+
+func normalizeFields(fields map[string]interface{}) map[string]string {
+	normalized := make(map[string]string, len(fields))
+	for name, value := range fields {
+		if value == nil {
+			normalized[name] = ""
+			continue
+		}
+		normalized[name] = fmt.Sprint(value)
+	}
+	return normalized
+}
+
+// hashFields 对字段内容计算摘要，json 序列化 map 时按键排序，结果稳定
+func hashFields(fields map[string]string) string {
+	data, _ := json.Marshal(fields)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// diffFields 以 source 的字段为准与 target 比较，返回按字段名排序的变更
+func diffFields(target, source map[string]interface{}) ChangeSet {
+	oldValues := normalizeFields(target)
+	newValues := normalizeFields(source)
+	for name := range oldValues {
+		if _, exists := newValues[name]; !exists {
+			delete(oldValues, name)
+		}
+	}
+	if hashFields(oldValues) == hashFields(newValues) {
+		return nil
+	}
+
+	names := make([]string, 0, len(newValues))
+	for name := range newValues {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var changes ChangeSet
+	for _, name := range names {
+		if oldValues[name] != newValues[name] {
+			changes = append(changes, FieldChange{Field: name, Old: oldValues[name], New: newValues[name]})
+		}
+	}
+	return changes
+}
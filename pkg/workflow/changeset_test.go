@@ -0,0 +1,69 @@
+package workflow
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffFields(t *testing.T) {
+	tests := []struct {
+		name   string
+		target map[string]interface{}
+		source map[string]interface{}
+		want   ChangeSet
+	}{
+		{
+			name:   "unchanged",
+			target: map[string]interface{}{`销售`: "张三", `规格`: "10"},
+			source: map[string]interface{}{`销售`: "张三", `规格`: "10"},
+			want:   nil,
+		},
+		{
+			name:   "values normalized to text",
+			target: map[string]interface{}{`规格`: "10", `订阅结束时间`: 1700000000000, `系统版本`: nil},
+			source: map[string]interface{}{`规格`: 10, `订阅结束时间`: 1700000000000, `系统版本`: ""},
+			want:   nil,
+		},
+		{
+			name:   "changed",
+			target: map[string]interface{}{`销售`: "张三", `规格`: "10"},
+			source: map[string]interface{}{`销售`: "李四", `规格`: "10"},
+			want:   ChangeSet{{Field: `销售`, Old: "张三", New: "李四"}},
+		},
+		{
+			name:   "sorted by field",
+			target: map[string]interface{}{`销售`: "张三", `交付负责人`: "王五", `规格`: "10"},
+			source: map[string]interface{}{`销售`: "李四", `交付负责人`: "赵六", `规格`: "10"},
+			want: ChangeSet{
+				{Field: `交付负责人`, Old: "王五", New: "赵六"},
+				{Field: `销售`, Old: "张三", New: "李四"},
+			},
+		},
+		{
+			name:   "unmapped target fields ignored",
+			target: map[string]interface{}{`销售`: "张三", `维护记录`: "1-[巡检]", `服务状态`: "服务中"},
+			source: map[string]interface{}{`销售`: "张三"},
+			want:   nil,
+		},
+		{
+			name:   "missing target field",
+			target: map[string]interface{}{`销售`: "张三"},
+			source: map[string]interface{}{`销售`: "张三", `部署架构`: "单机"},
+			want:   ChangeSet{{Field: `部署架构`, Old: "", New: "单机"}},
+		},
+		{
+			name:   "new record",
+			target: nil,
+			source: map[string]interface{}{`销售`: "张三"},
+			want:   ChangeSet{{Field: `销售`, Old: "", New: "张三"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := diffFields(tt.target, tt.source)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diffFields() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package workflow
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"support-workflow/pkg/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// checkpointKeys 任务名称到缓存中 marker 键的映射
+var checkpointKeys = map[string]string{
+	MaintenanceTaskName:       MaintenanceLastMarker,
+	MaintenanceRecordTaskName: MaintenanceRecordLastMarker,
+}
+
+// CheckpointNamespace 增量同步 marker 的存储桶，键为 checkpointKeys 中的名称
+const CheckpointNamespace = "checkpoints"
+
+type Checkpoint struct {
+	Task   string `json:"task"`
+	Key    string `json:"key"`
+	Marker int    `json:"marker"`
+	// Saved 为 false 表示还没有保存过 marker，下次同步从头开始
+	Saved bool `json:"saved"`
+}
+
+type CheckpointRequest struct {
+	Marker *int `json:"marker" binding:"required"`
+}
+
+func checkpointBucket(cache *utils.Cache) *utils.TypedBucket[int] {
+	return utils.NewTypedBucket[int](cache, CheckpointNamespace)
+}
+
+func loadCheckpoint(cache *utils.Cache, key string) (int, bool, error) {
+	return checkpointBucket(cache).Get(key)
+}
+
+func saveCheckpoint(cache *utils.Cache, key string, marker int) error {
+	return checkpointBucket(cache).Put(key, marker)
+}
+
+func resetCheckpoint(cache *utils.Cache, key string) error {
+	return checkpointBucket(cache).Delete(key)
+}
+
+// ErrNoCheckpoint 任务不做增量同步，没有 marker
+var ErrNoCheckpoint = errors.New("task has no checkpoint")
+
+// GetCheckpoint 读取任务当前的 marker
+func GetCheckpoint(cache *utils.Cache, task string) (*Checkpoint, error) {
+	key, exists := checkpointKeys[task]
+	if !exists {
+		return nil, ErrNoCheckpoint
+	}
+	marker, saved, err := loadCheckpoint(cache, key)
+	if err != nil {
+		return nil, err
+	}
+	return &Checkpoint{Task: task, Key: key, Marker: marker, Saved: saved}, nil
+}
+
+// ListCheckpoints 按任务名称排序列出全部 marker
+func ListCheckpoints(cache *utils.Cache) ([]Checkpoint, error) {
+	tasks := make([]string, 0, len(checkpointKeys))
+	for task := range checkpointKeys {
+		tasks = append(tasks, task)
+	}
+	sort.Strings(tasks)
+	checkpoints := make([]Checkpoint, 0, len(tasks))
+	for _, task := range tasks {
+		checkpoint, err := GetCheckpoint(cache, task)
+		if err != nil {
+			return nil, err
+		}
+		checkpoints = append(checkpoints, *checkpoint)
+	}
+	return checkpoints, nil
+}
+
+// RewindCheckpoint 把任务的 marker 设置为指定值，下次同步从该位置开始
+func RewindCheckpoint(cache *utils.Cache, task string, marker int) (*Checkpoint, error) {
+	key, exists := checkpointKeys[task]
+	if !exists {
+		return nil, ErrNoCheckpoint
+	}
+	if marker < 0 {
+		return nil, fmt.Errorf("marker must not be negative")
+	}
+	if err := saveCheckpoint(cache, key, marker); err != nil {
+		return nil, err
+	}
+	return &Checkpoint{Task: task, Key: key, Marker: marker, Saved: true}, nil
+}
+
+// ResetCheckpoint 删除任务的 marker，下次同步从头开始
+func ResetCheckpoint(cache *utils.Cache, task string) error {
+	key, exists := checkpointKeys[task]
+	if !exists {
+		return ErrNoCheckpoint
+	}
+	return resetCheckpoint(cache, key)
+}
+
+func (tm *TaskManager) listCheckpoints(c *gin.Context) {
+	checkpoints, err := ListCheckpoints(tm.Cache)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": checkpoints})
+}
+
+func (tm *TaskManager) showCheckpoint(c *gin.Context) {
+	task := c.Param("task")
+	if _, exists := checkpointKeys[task]; !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "task not found"})
+		return
+	}
+	checkpoint, err := GetCheckpoint(tm.Cache, task)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": checkpoint})
+}
+
+func (tm *TaskManager) rewindCheckpoint(c *gin.Context) {
+	task := c.Param("task")
+	if _, exists := checkpointKeys[task]; !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "task not found"})
+		return
+	}
+	checkpointReq := CheckpointRequest{}
+	if err := c.ShouldBindJSON(&checkpointReq); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if *checkpointReq.Marker < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "marker must not be negative"})
+		return
+	}
+	checkpoint, err := RewindCheckpoint(tm.Cache, task, *checkpointReq.Marker)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": checkpoint})
+}
+
+func (tm *TaskManager) deleteCheckpoint(c *gin.Context) {
+	task := c.Param("task")
+	if _, exists := checkpointKeys[task]; !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "task not found"})
+		return
+	}
+	if err := ResetCheckpoint(tm.Cache, task); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "重置成功，下次同步将从头开始"})
+}
@@ -0,0 +1,247 @@
+package workflow
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"support-workflow/pkg/config"
+	"support-workflow/pkg/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// FieldWriteStateNamespace 各飞书行的字段写入状态，键为飞书记录 ID
+	FieldWriteStateNamespace = "field-write-state"
+	// ConflictReviewNamespace 待复核的冲突，键为复核 ID
+	ConflictReviewNamespace = "conflict-reviews"
+
+	PolicySourceWins    = "source-wins"
+	PolicyFeishuWins    = "feishu-wins"
+	PolicyFlagForReview = "flag-for-review"
+
+	ChangeSourceReview = "review"
+)
+
+// FieldWriteState 记录每个飞书行各字段最近一次由同步写入的值，用于判断是否被人工修改过
+type FieldWriteState struct {
+	Written map[string]string `json:"written"`
+	// Dismissed 复核时选择保留飞书值的字段，对应被拒绝的 Support 值；Support 值再变化时才重新处理
+	Dismissed map[string]string `json:"dismissed"`
+}
+
+type ConflictReview struct {
+	ID          string      `json:"id"`
+	RecordID    string      `json:"recordId"`
+	Company     string      `json:"company"`
+	Field       string      `json:"field"`
+	FeishuValue string      `json:"feishuValue"`
+	SourceValue string      `json:"sourceValue"`
+	SourceRaw   interface{} `json:"sourceRaw"`
+	LastWritten string      `json:"lastWritten"`
+	CreatedAt   int64       `json:"createdAt"`
+	UpdatedAt   int64       `json:"updatedAt"`
+}
+
+type ResolveRequest struct {
+	Action string `json:"action" binding:"required,oneof=source feishu"`
+}
+
+func fieldPolicy(field string) string {
+	conf := config.GetConf()
+	if policy, exists := conf.FieldConflictPolicies[field]; exists {
+		return policy
+	}
+	return conf.DefaultConflictPolicy
+}
+
+func fieldWriteStateBucket(cache *utils.Cache) *utils.TypedBucket[*FieldWriteState] {
+	return utils.NewTypedBucket[*FieldWriteState](cache, FieldWriteStateNamespace)
+}
+
+func conflictReviewBucket(cache *utils.Cache) *utils.TypedBucket[ConflictReview] {
+	return utils.NewTypedBucket[ConflictReview](cache, ConflictReviewNamespace)
+}
+
+func loadFieldWriteState(cache *utils.Cache, recordID string) (*FieldWriteState, error) {
+	state, _, err := fieldWriteStateBucket(cache).Get(recordID)
+	if state == nil {
+		state = &FieldWriteState{}
+	}
+	if state.Written == nil {
+		state.Written = make(map[string]string)
+	}
+	if state.Dismissed == nil {
+		state.Dismissed = make(map[string]string)
+	}
+	return state, err
+}
+
+func saveFieldWriteState(cache *utils.Cache, recordID string, state *FieldWriteState) error {
+	return fieldWriteStateBucket(cache).Put(recordID, state)
+}
+
+// markWritten 写入飞书成功后记录各字段的值
+func markWritten(cache *utils.Cache, recordID string, values map[string]string) error {
+	state, err := loadFieldWriteState(cache, recordID)
+	if err != nil {
+		return err
+	}
+	for field, value := range values {
+		state.Written[field] = value
+		delete(state.Dismissed, field)
+	}
+	return saveFieldWriteState(cache, recordID, state)
+}
+
+func flagForReview(cache *utils.Cache, items []ConflictReview) error {
+	if len(items) == 0 {
+		return nil
+	}
+	bucket := conflictReviewBucket(cache)
+	ids := make([]string, 0, len(items))
+	for _, item := range items {
+		ids = append(ids, item.ID)
+	}
+	reviews, err := bucket.GetBatch(ids)
+	if err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	for _, item := range items {
+		if existing, exists := reviews[item.ID]; exists {
+			item.CreatedAt = existing.CreatedAt
+		} else {
+			item.CreatedAt = now
+		}
+		item.UpdatedAt = now
+		reviews[item.ID] = item
+	}
+	return bucket.PutBatch(reviews)
+}
+
+// applyConflictPolicy 过滤掉被人工修改且策略不允许覆盖的字段，需要复核的字段由调用方加入复核队列
+func applyConflictPolicy(cache *utils.Cache, recordID, company string, changes ChangeSet, fields map[string]interface{}) (ChangeSet, []ConflictReview, error) {
+	state, err := loadFieldWriteState(cache, recordID)
+	if err != nil {
+		return nil, nil, err
+	}
+	var applied ChangeSet
+	var reviews []ConflictReview
+	for _, change := range changes {
+		if dismissed, exists := state.Dismissed[change.Field]; exists && dismissed == change.New {
+			continue
+		}
+		lastWritten, known := state.Written[change.Field]
+		if !known || lastWritten == change.Old {
+			applied = append(applied, change)
+			continue
+		}
+		switch fieldPolicy(change.Field) {
+		case PolicyFeishuWins:
+			log.Printf("Keep manual edit of %s %s: %q", company, change.Field, change.Old)
+		case PolicyFlagForReview:
+			reviews = append(reviews, ConflictReview{
+				ID: recordID + "|" + change.Field, RecordID: recordID, Company: company,
+				Field: change.Field, FeishuValue: change.Old, SourceValue: change.New,
+				SourceRaw: fields[change.Field], LastWritten: lastWritten,
+			})
+		default:
+			log.Printf("Overwrite manual edit of %s %s: %q -> %q", company, change.Field, change.Old, change.New)
+			applied = append(applied, change)
+		}
+	}
+	return applied, reviews, nil
+}
+
+// seedWriteState 对尚无记录且与 Support 一致的字段补记基线，之后的人工修改才能被识别
+func seedWriteState(cache *utils.Cache, recordID string, current, fields map[string]interface{}) error {
+	state, err := loadFieldWriteState(cache, recordID)
+	if err != nil {
+		return err
+	}
+	currentValues := normalizeFields(current)
+	seeded := false
+	for field, value := range normalizeFields(fields) {
+		if _, known := state.Written[field]; known || currentValues[field] != value {
+			continue
+		}
+		state.Written[field] = value
+		seeded = true
+	}
+	if !seeded {
+		return nil
+	}
+	return saveFieldWriteState(cache, recordID, state)
+}
+
+func (tm *TaskManager) listConflictReviews(c *gin.Context) {
+	entries, err := conflictReviewBucket(tm.Cache).List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	items := make([]ConflictReview, 0, len(entries))
+	for _, entry := range entries {
+		items = append(items, entry.Value)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].CreatedAt < items[j].CreatedAt })
+	c.JSON(http.StatusOK, gin.H{"data": items, "total": len(items)})
+}
+
+func (tm *TaskManager) resolveConflictReview(c *gin.Context) {
+	resolveReq := ResolveRequest{}
+	if err := c.ShouldBindJSON(&resolveReq); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	bucket := conflictReviewBucket(tm.Cache)
+	item, exists, err := bucket.Get(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "review not found"})
+		return
+	}
+
+	if err = resolveConflict(tm.Cache, item, resolveReq.Action); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err = bucket.Delete(item.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "处理成功"})
+}
+
+func resolveConflict(cache *utils.Cache, item ConflictReview, action string) error {
+	if action == "feishu" {
+		state, err := loadFieldWriteState(cache, item.RecordID)
+		if err != nil {
+			return err
+		}
+		state.Dismissed[item.Field] = item.SourceValue
+		return saveFieldWriteState(cache, item.RecordID, state)
+	}
+
+	fields := map[string]interface{}{item.Field: item.SourceRaw}
+	if err := updateFeishuRecordFields(item.RecordID, fields); err != nil {
+		return fmt.Errorf("update %s failed: %w", item.Company, err)
+	}
+	recordChange(cache, ChangeLogEntry{
+		RecordID: item.RecordID, Company: item.Company, Action: ChangeActionUpdate,
+		Changes: ChangeSet{{Field: item.Field, Old: item.FeishuValue, New: item.SourceValue}},
+		Task:    "冲突复核", RunID: newRunID(), Source: ChangeSourceReview,
+	})
+	return markWritten(cache, item.RecordID, map[string]string{item.Field: item.SourceValue})
+}
+
+func reviewsPage(c *gin.Context) {
+	c.HTML(http.StatusOK, "reviews.html", nil)
+}
@@ -0,0 +1,99 @@
+package workflow
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	DiffActionCreate = "create"
+	DiffActionUpdate = "update"
+	DiffActionAppend = "append"
+	DiffActionPark   = "park"
+	DiffActionReview = "review"
+)
+
+// diffSymbols 文本报告中各动作的标记，未列出的动作使用 *
+var diffSymbols = map[string]string{
+	DiffActionCreate: "+", DiffActionUpdate: "~", DiffActionAppend: ">",
+	DiffActionPark: "?", DiffActionReview: "!", DiffActionMissing: "-", DiffActionRemind: "@",
+}
+
+// DiffEntry 演练模式下一次本应发生的写入
+type DiffEntry struct {
+	Action   string    `json:"action"`
+	Company  string    `json:"company"`
+	RecordID string    `json:"recordId,omitempty"`
+	Changes  ChangeSet `json:"changes,omitempty"`
+	Note     string    `json:"note,omitempty"`
+}
+
+// DiffReport 演练模式的结果，只收集不写入飞书
+type DiffReport struct {
+	mu      sync.Mutex
+	Task    string         `json:"task"`
+	RunID   string         `json:"runId"`
+	Summary map[string]int `json:"summary"`
+	Entries []DiffEntry    `json:"entries"`
+}
+
+func NewDiffReport(run *TaskRun) *DiffReport {
+	return &DiffReport{
+		Task: run.Task, RunID: run.ID,
+		Summary: make(map[string]int), Entries: make([]DiffEntry, 0),
+	}
+}
+
+func (r *DiffReport) Add(entry DiffEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Summary[entry.Action] += 1
+	r.Entries = append(r.Entries, entry)
+}
+
+func (r *DiffReport) Text() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var builder strings.Builder
+	builder.WriteString(fmt.Sprintf("[dry-run] %s (%s): ", r.Task, r.RunID))
+	// 写入类动作始终展示，其余动作（消失的客户、到期提醒等）有记录时才展示
+	actions := []string{DiffActionCreate, DiffActionUpdate, DiffActionAppend, DiffActionPark, DiffActionReview}
+	listed := make(map[string]bool, len(actions))
+	for _, action := range actions {
+		listed[action] = true
+	}
+	var others []string
+	for action := range r.Summary {
+		if !listed[action] {
+			others = append(others, action)
+		}
+	}
+	sort.Strings(others)
+	var counts []string
+	for _, action := range append(actions, others...) {
+		counts = append(counts, fmt.Sprintf("%d %s", r.Summary[action], action))
+	}
+	builder.WriteString(strings.Join(counts, ", "))
+	builder.WriteString("\n")
+
+	for _, entry := range r.Entries {
+		symbol, known := diffSymbols[entry.Action]
+		if !known {
+			symbol = "*"
+		}
+		builder.WriteString(fmt.Sprintf("%s %s %s", symbol, entry.Action, entry.Company))
+		if entry.RecordID != "" {
+			builder.WriteString(fmt.Sprintf(" (%s)", entry.RecordID))
+		}
+		if entry.Note != "" {
+			builder.WriteString(": " + entry.Note)
+		}
+		builder.WriteString("\n")
+		for _, change := range entry.Changes {
+			builder.WriteString(fmt.Sprintf("    %s: %q -> %q\n", change.Field, change.Old, change.New))
+		}
+	}
+	return builder.String()
+}
@@ -3,10 +3,13 @@ package workflow
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
 
 	"support-workflow/pkg/config"
@@ -20,20 +23,55 @@ import (
 type CompanyRequest struct {
 	CompanyName string `json:"companyName"`
 	ProductName string `json:"productName"`
+	Submitter   string `json:"submitter"`
+	// SaleUser、DeliveryUser 可选，写入飞书行并加入支持群
+	SaleUser     string `json:"saleUser"`
+	DeliveryUser string `json:"deliveryUser"`
 }
 
 type HttpServer struct {
+	mu     sync.Mutex
 	server *http.Server
 	router *gin.Engine
 }
 
-func NewHttpServer() *HttpServer {
+func NewHttpServer(taskManager *TaskManager) *HttpServer {
 	conf := config.GetConf()
+	gin.DefaultWriter = config.LogWriter
+	gin.DefaultErrorWriter = config.LogWriter
 	r := gin.Default()
 	r.LoadHTMLGlob("templates/*")
 	r.Static("/static", "./static")
 	r.GET("/", index)
-	r.POST("/companies", createCompany)
+	r.POST("/companies", taskManager.createCompany)
+	r.GET("/reviews", reviewsPage)
+
+	api := r.Group("/api")
+	api.GET("/pending-records", taskManager.listPendingRecords)
+	api.POST("/pending-records/:id/mapping", taskManager.mapPendingRecord)
+	api.DELETE("/pending-records/:id", taskManager.deletePendingRecord)
+	api.GET("/parked-maintenances", taskManager.listParkedMaintenances)
+	api.DELETE("/parked-maintenances/:id", taskManager.deleteParkedMaintenance)
+	api.GET("/checkpoints", taskManager.listCheckpoints)
+	api.GET("/checkpoints/:task", taskManager.showCheckpoint)
+	api.PUT("/checkpoints/:task", taskManager.rewindCheckpoint)
+	api.DELETE("/checkpoints/:task", taskManager.deleteCheckpoint)
+	api.GET("/changes", taskManager.listChanges)
+	api.GET("/missing-customers", taskManager.listMissingCustomers)
+	api.POST("/tasks/:name/run", taskManager.triggerTask)
+	api.GET("/tasks/health", taskManager.listTaskHealth)
+	api.GET("/outbox", taskManager.listOutbox)
+	api.POST("/outbox/:id/resend", taskManager.resendOutbox)
+	api.GET("/reviews", taskManager.listConflictReviews)
+	api.POST("/reviews/:id/resolve", taskManager.resolveConflictReview)
+	api.GET("/cache/stats", taskManager.cacheStats)
+	api.POST("/cache/sweep", taskManager.sweepCache)
+	api.GET("/cache/namespaces", taskManager.listCacheNamespaces)
+	api.GET("/cache/namespaces/:namespace", taskManager.listCacheKeys)
+	api.GET("/cache/namespaces/:namespace/keys/*key", taskManager.showCacheKey)
+	api.DELETE("/cache/namespaces/:namespace/keys/*key", taskManager.deleteCacheKey)
+	api.GET("/cache/export", taskManager.exportCache)
+	api.POST("/cache/import", taskManager.importCache)
 
 	return &HttpServer{
 		server: &http.Server{
@@ -45,15 +83,49 @@ func NewHttpServer() *HttpServer {
 }
 
 func (s *HttpServer) Start() error {
-	log.Printf("HTTP服务器启动，监听端口 %v\n", s.server.Addr)
-	return s.server.ListenAndServe()
+	s.mu.Lock()
+	server := s.server
+	s.mu.Unlock()
+	log.Printf("HTTP服务器启动，监听端口 %v\n", server.Addr)
+	return server.ListenAndServe()
 }
 
 func (s *HttpServer) Stop() {
 	log.Println("正在优雅关闭HTTP服务器...")
+	s.mu.Lock()
+	server := s.server
+	s.mu.Unlock()
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	_ = s.server.Shutdown(ctx)
+	_ = server.Shutdown(ctx)
+}
+
+// Reconfigure 配置变更回调，端口变化时先监听新端口，成功后再关闭旧端口，失败时继续使用旧端口
+func (s *HttpServer) Reconfigure(old, new config.Config) {
+	if old.Port == new.Port {
+		return
+	}
+	addr := fmt.Sprintf(":%v", new.Port)
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Printf("HTTP服务器切换到端口 %v 失败，继续使用 %v: %v", new.Port, old.Port, err)
+		return
+	}
+	server := &http.Server{Addr: addr, Handler: s.router}
+	s.mu.Lock()
+	previous := s.server
+	s.server = server
+	s.mu.Unlock()
+
+	go func() {
+		log.Printf("HTTP服务器切换到端口 %v\n", new.Port)
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("HTTP服务器运行失败: %v", err)
+		}
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_ = previous.Shutdown(ctx)
 }
 
 func index(c *gin.Context) {
@@ -65,13 +137,37 @@ type TypeTextField struct {
 	Text string `json:"text"`
 }
 
+// FlexText 飞书文本字段返回 [{type,text}]，单选等字段返回字符串，统一按文本读取
+type FlexText string
+
+func (t *FlexText) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*t = FlexText(text)
+		return nil
+	}
+	var segments []TypeTextField
+	if err := json.Unmarshal(data, &segments); err != nil {
+		return err
+	}
+	var builder strings.Builder
+	for _, segment := range segments {
+		builder.WriteString(segment.Text)
+	}
+	*t = FlexText(builder.String())
+	return nil
+}
+
 type Fields struct {
 	Serial             int             `json:"编号"`
+	FinalName          FlexText        `json:"最终客户名称"`
 	CreatorName        string          `json:"交付负责人"`
 	CompanyFullName    []TypeTextField `json:"客户全称"`
 	ServiceStatus      string          `json:"服务状态"` // 暂时先不更新了，Support 是 bool 类型
 	AbbreviatedName    string          `json:"简称"`
 	ProductVersion     []TypeTextField `json:"系统版本"`
+	DeployArch         FlexText        `json:"部署架构"`
+	ServiceType        FlexText        `json:"订阅类型"`
 	SupportEndDate     int             `json:"维保结束时间"`
 	Amount             string          `json:"规格"`
 	StartDate          int             `json:"订阅开始时间"`
@@ -96,6 +192,45 @@ type FeishuResponse struct {
 	} `json:"data"`
 }
 
+// loadFeishuRecords 一次性获取飞书表格中的全部客户行，按客户全称索引；API 有限额
+func loadFeishuRecords(records map[string]Record) error {
+	pageToken := ""
+	conf := config.GetConf()
+	client := utils.NewFeishuClient()
+	for {
+		req := larkbitable.NewSearchAppTableRecordReqBuilder().
+			AppToken(conf.FeishuTableAppToken).
+			TableId(conf.FeishuTableID).
+			PageSize(500).
+			PageToken(pageToken).
+			Build()
+		resp, err := client.Client.Bitable.V1.AppTableRecord.Search(context.Background(), req)
+		if err != nil {
+			return err
+		}
+
+		if !resp.Success() {
+			return fmt.Errorf("get feishu record request failed: %s", resp.RawBody)
+		}
+		var instResp FeishuResponse
+		if err = json.Unmarshal(resp.RawBody, &instResp); err != nil {
+			return fmt.Errorf("解析表格中是否存在实施记录失败: %w", err)
+		}
+
+		pageToken = instResp.Data.PageToken
+		for _, record := range instResp.Data.Records {
+			if len(record.Fields.CompanyFullName) < 1 {
+				continue
+			}
+			records[record.Fields.CompanyFullName[0].Text] = record
+		}
+		if !instResp.Data.HasMore {
+			break
+		}
+	}
+	return nil
+}
+
 func GetMaxSerialFromFeishu() (int, error) {
 	conf := config.GetConf()
 	client := utils.NewFeishuClient()
@@ -136,7 +271,8 @@ func GetMaxSerialFromFeishu() (int, error) {
 	return maxNumber, nil
 }
 
-func InsertRecordToFeishu(companyName string) (string, *larkbitable.AppTableRecord, error) {
+func InsertRecordToFeishu(cache *utils.Cache, companyReq CompanyRequest) (string, *larkbitable.AppTableRecord, error) {
+	companyName := companyReq.CompanyName
 	conf := config.GetConf()
 	client := utils.NewFeishuClient()
 
@@ -146,14 +282,21 @@ func InsertRecordToFeishu(companyName string) (string, *larkbitable.AppTableReco
 	}
 	companySerial := serial + 1
 	fullName := fmt.Sprintf("%d-%s", companySerial, companyName)
+	fields := map[string]interface{}{
+		`最终客户名称`: fullName,
+		`编号`:     companySerial,
+		`客户全称`:   companyName,
+	}
+	if companyReq.SaleUser != "" {
+		fields[`销售`] = companyReq.SaleUser
+	}
+	if companyReq.DeliveryUser != "" {
+		fields[`交付负责人`] = companyReq.DeliveryUser
+	}
 	insertReq := larkbitable.NewCreateAppTableRecordReqBuilder().
 		AppToken(conf.FeishuTableAppToken).TableId(conf.FeishuTableID).
 		AppTableRecord(larkbitable.NewAppTableRecordBuilder().
-			Fields(map[string]interface{}{
-				`最终客户名称`: fullName,
-				`编号`:     companySerial,
-				`客户全称`:   companyName,
-			}).
+			Fields(fields).
 			Build()).
 		Build()
 
@@ -164,43 +307,91 @@ func InsertRecordToFeishu(companyName string) (string, *larkbitable.AppTableReco
 	if !insertResp.Success() {
 		return "", nil, fmt.Errorf("insert row failed: %s", larkcore.Prettify(insertResp.CodeError))
 	}
+	recordChange(cache, ChangeLogEntry{
+		RecordID: larkcore.StringValue(insertResp.Data.Record.RecordId), Company: companyName,
+		Action: ChangeActionCreate, Changes: diffFields(nil, fields),
+		Task: "新客户登记", RunID: newRunID(), Source: ChangeSourceWebForm,
+	})
 	return fullName, insertResp.Data.Record, nil
 }
 
-func createCompany(c *gin.Context) {
+func (tm *TaskManager) createCompany(c *gin.Context) {
 	companyReq := CompanyRequest{}
 	if err := c.ShouldBindJSON(&companyReq); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	warning, err := tm.RegisterCompany(companyReq)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if warning != "" {
+		c.JSON(http.StatusOK, gin.H{"message": "提交成功", "warning": warning})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "提交成功"})
+}
 
+// RegisterCompany 登记新客户：写入飞书表格、按配置自动建群，并把登记通知放入发件箱；
+// 飞书记录写入后的失败只作为 warning 返回，调用方不应重试，否则会重复登记和建群
+func (tm *TaskManager) RegisterCompany(companyReq CompanyRequest) (string, error) {
 	conf := config.GetConf()
-	webhookUrl := conf.WechatGroupRobotWebhook
 	remindPhones := strings.Split(conf.RobotRemindsMobilePhones, ",")
-	fullName, _, err := InsertRecordToFeishu(companyReq.CompanyName)
+	fullName, record, err := InsertRecordToFeishu(tm.Cache, companyReq)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
+		return "", err
 	}
-	reqBody := map[string]interface{}{
-		"msgtype": "text",
-		"text": map[string]interface{}{
-			"content": fmt.Sprintf(
-				"%s-%s-支持群", fullName, companyReq.ProductName,
-			),
-			"mentioned_mobile_list": remindPhones,
+	serial := fmt.Sprint(record.Fields[`编号`])
+	submitter := companyReq.Submitter
+	if submitter == "" {
+		submitter = "未填写"
+	}
+	chatName := fmt.Sprintf("%s-%s-支持群", fullName, companyReq.ProductName)
+	msg := utils.Message{
+		Title:   "新客户登记",
+		Content: chatName,
+		Fields: []utils.MessageField{
+			{Key: "客户", Value: companyReq.CompanyName},
+			{Key: "产品", Value: companyReq.ProductName},
+			{Key: "编号", Value: serial},
+			{Key: "提交人", Value: submitter},
 		},
+		URL:            conf.FeishuTableURL,
+		MentionMobiles: remindPhones,
 	}
-
-	client := utils.NewClient(webhookUrl)
-	for i := 0; i < 5; i++ {
-		resp, err := client.Post("", reqBody)
-		if err == nil && resp.StatusCode == 200 {
-			break
+	// 自动建群成功后通知中不再要求人工建群，失败时附上原因由人工处理
+	desc := "请创建支持群"
+	if len(conf.SupportChatProviders) > 0 {
+		recordID := larkcore.StringValue(record.RecordId)
+		owners := []string{companyReq.SaleUser, companyReq.DeliveryUser}
+		chatIDs, chatErr := createSupportChats(tm.Cache, recordID, companyReq.CompanyName, chatName, owners)
+		if len(chatIDs) > 0 {
+			desc = "已自动创建支持群"
+			msg.MentionMobiles = nil
 		}
-		if i == 4 {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Call wechat robot webhook failed."})
+		if chatErr != nil {
+			log.Printf("Create support chat %s failed: %v", chatName, chatErr)
+			desc = "自动建群失败，请人工处理"
+			if len(chatIDs) > 0 {
+				desc = "部分渠道建群失败，请人工处理"
+			}
+			msg.MentionMobiles = remindPhones
+			msg.Fields = append(msg.Fields, utils.MessageField{Key: "建群失败", Value: chatErr.Error()})
 		}
 	}
-	c.JSON(http.StatusOK, gin.H{"message": "提交成功"})
+	msg.Content = fmt.Sprintf("%s：%s", desc, chatName)
+	msg.Wecom = utils.NewWecomTextNotice("新客户登记", desc).
+		Emphasis(serial, "客户编号").
+		Field("客户", companyReq.CompanyName).
+		Field("产品", companyReq.ProductName).
+		Field("提交人", submitter).
+		SubTitle(chatName).
+		Link("查看飞书表格", conf.FeishuTableURL).
+		Build()
+	if err = tm.Outbox.Enqueue(utils.EventOnboarding, msg); err != nil {
+		log.Printf("Enqueue onboarding notice of %s failed: %v", companyReq.CompanyName, err)
+		return fmt.Sprintf("登记成功，但通知发送失败，请人工通知: %v", err), nil
+	}
+	return "", nil
 }
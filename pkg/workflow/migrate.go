@@ -0,0 +1,139 @@
+package workflow
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"support-workflow/pkg/utils"
+)
+
+// legacyFieldWriteStatePrefix 旧版字段写入状态的键前缀，后接飞书记录 ID
+const legacyFieldWriteStatePrefix = "FieldWriteState:"
+
+// OpenCache 打开缓存并把旧版存储桶中的数据迁移到各命名空间，服务和命令行都通过它打开缓存
+func OpenCache(backend, path string) (*utils.Cache, error) {
+	cache, err := utils.OpenCache(backend, path)
+	if err != nil {
+		return nil, err
+	}
+	if err = migrateLegacyCache(cache); err != nil {
+		_ = cache.Close()
+		return nil, fmt.Errorf("migrate legacy cache failed: %w", err)
+	}
+	return cache, nil
+}
+
+// migrateMap 旧版整体保存的映射按键拆开写入命名空间，映射的 JSON 键都是字符串
+func migrateMap[T any](cache *utils.Cache, legacyKey, namespace string) error {
+	var values map[string]T
+	found, err := cache.TakeLegacy(legacyKey, &values)
+	if err != nil || !found {
+		return err
+	}
+	if err = utils.NewTypedBucket[T](cache, namespace).PutBatch(values); err != nil {
+		return err
+	}
+	log.Printf("Migrate %s to %s: %d keys", legacyKey, namespace, len(values))
+	return cache.DropLegacy(legacyKey)
+}
+
+// migrateValue 旧版单个值写入命名空间中的 key
+func migrateValue[T any](cache *utils.Cache, legacyKey, namespace, key string) error {
+	var value T
+	found, err := cache.TakeLegacy(legacyKey, &value)
+	if err != nil || !found {
+		return err
+	}
+	if err = utils.NewTypedBucket[T](cache, namespace).Put(key, value); err != nil {
+		return err
+	}
+	log.Printf("Migrate %s to %s/%s", legacyKey, namespace, key)
+	return cache.DropLegacy(legacyKey)
+}
+
+// migrateReminderSent 按发送时间补上过期时间，已超过一年的直接丢弃
+func migrateReminderSent(cache *utils.Cache) error {
+	var sent map[string]int64
+	found, err := cache.TakeLegacy("ReminderSent", &sent)
+	if err != nil || !found {
+		return err
+	}
+	bucket := utils.NewTypedBucket[int64](cache, ReminderSentNamespace)
+	for key, sentAt := range sent {
+		ttl := time.Until(time.Unix(sentAt, 0).Add(reminderSentTTL))
+		if ttl <= 0 {
+			continue
+		}
+		if err = bucket.PutTTL(key, sentAt, ttl); err != nil {
+			return err
+		}
+	}
+	log.Printf("Migrate ReminderSent to %s: %d keys", ReminderSentNamespace, len(sent))
+	return cache.DropLegacy("ReminderSent")
+}
+
+func migrateLegacyCache(cache *utils.Cache) error {
+	steps := []func() error{
+		func() error { return migrateMap[*TaskHealth](cache, "TaskHealth", TaskHealthNamespace) },
+		func() error { return migrateReminderSent(cache) },
+		func() error {
+			return migrateMap[PendingMaintenanceRecord](cache, "MaintenanceRecordPending", PendingRecordNamespace)
+		},
+		func() error {
+			return migrateMap[string](cache, "MaintenanceRecordCompanyMapping", CompanyMappingNamespace)
+		},
+		func() error {
+			return migrateMap[KnownCompany](cache, "MaintenanceKnownCompanies", KnownCompanyNamespace)
+		},
+		func() error { return migrateMap[ConflictReview](cache, "ConflictReviews", ConflictReviewNamespace) },
+		func() error {
+			return migrateValue[SyncDigest](cache, "SyncDigest", SyncDigestNamespace, syncDigestKey)
+		},
+		func() error {
+			return migrateValue[MissingCustomerReport](cache, "MaintenanceMissingCustomer", MissingCustomerNamespace, latestMissingReport)
+		},
+		func() error {
+			return migrateValue[int64](cache, MaintenanceLastFullSync, SyncStateNamespace, MaintenanceLastFullSync)
+		},
+	}
+	for _, key := range checkpointKeys {
+		key := key
+		steps = append(steps, func() error { return migrateValue[int](cache, key, CheckpointNamespace, key) })
+	}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			return err
+		}
+	}
+
+	keys, err := cache.LegacyKeys(legacyFieldWriteStatePrefix)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	states := make(map[string]*FieldWriteState, len(keys))
+	for _, key := range keys {
+		state := &FieldWriteState{}
+		found, err := cache.TakeLegacy(key, state)
+		if err != nil {
+			return err
+		}
+		if found {
+			states[strings.TrimPrefix(key, legacyFieldWriteStatePrefix)] = state
+		}
+	}
+	if err = fieldWriteStateBucket(cache).PutBatch(states); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err = cache.DropLegacy(key); err != nil {
+			return err
+		}
+	}
+	log.Printf("Migrate %d field write states to %s", len(states), FieldWriteStateNamespace)
+	return nil
+}
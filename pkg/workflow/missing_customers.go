@@ -0,0 +1,288 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"support-workflow/pkg/config"
+	"support-workflow/pkg/utils"
+
+	"github.com/gin-gonic/gin"
+	larkcore "github.com/larksuite/oapi-sdk-go/v3/core"
+	larkbitable "github.com/larksuite/oapi-sdk-go/v3/service/bitable/v1"
+)
+
+const (
+	// KnownCompanyNamespace 出现过的客户，键为客户名称
+	KnownCompanyNamespace = "known-companies"
+	// MissingCustomerNamespace 最近一次缺失客户检查的结果，只有 latestMissingReport 一个键
+	MissingCustomerNamespace = "missing-customers"
+	latestMissingReport      = "latest"
+
+	MissingPolicyReport   = "report"
+	MissingPolicyInactive = "inactive"
+	MissingPolicyStatus   = "status"
+	MissingPolicyArchive  = "archive"
+
+	ServiceStatusActive   = "服务中"
+	ServiceStatusExpired  = "已过期"
+	ServiceStatusInactive = "已停用"
+
+	DiffActionMissing   = "missing"
+	ChangeActionArchive = "archive"
+
+	// missingGuardRatio 消失的客户超过已知客户的该比例时视为 Support 返回的数据不完整，只报告不处理
+	missingGuardRatio = 0.3
+)
+
+// KnownCompany 曾在 Support 全量快照中出现过的客户，只有这些客户消失时才处理，避免误伤网页登记的新客户；
+// 首次检查时用飞书中已有的客户初始化，上线前已从 Support 消失的客户也能发现
+type KnownCompany struct {
+	Expired  bool  `json:"expired"`
+	LastSeen int64 `json:"lastSeen"`
+	// MissingSince 首次发现消失的时间，再次出现在快照中时清除
+	MissingSince int64 `json:"missingSince,omitempty"`
+}
+
+type MissingCustomer struct {
+	Company  string `json:"company"`
+	RecordID string `json:"recordId"`
+	LastSeen int64  `json:"lastSeen"`
+	Policy   string `json:"policy"`
+	Status   string `json:"status"`
+}
+
+type MissingCustomerReport struct {
+	RunID     string `json:"runId"`
+	CheckedAt int64  `json:"checkedAt"`
+	// Guarded 快照异常时跳过处理的原因
+	Guarded   string            `json:"guarded,omitempty"`
+	Customers []MissingCustomer `json:"customers"`
+}
+
+func knownCompanyBucket(cache *utils.Cache) *utils.TypedBucket[KnownCompany] {
+	return utils.NewTypedBucket[KnownCompany](cache, KnownCompanyNamespace)
+}
+
+func missingCustomerBucket(cache *utils.Cache) *utils.TypedBucket[MissingCustomerReport] {
+	return utils.NewTypedBucket[MissingCustomerReport](cache, MissingCustomerNamespace)
+}
+
+func missingServiceStatus(policy string, known KnownCompany) string {
+	switch policy {
+	case MissingPolicyInactive:
+		return ServiceStatusInactive
+	case MissingPolicyStatus:
+		if known.Expired {
+			return ServiceStatusExpired
+		}
+		return ServiceStatusActive
+	}
+	return ""
+}
+
+// missingHandled 已经记录过且策略已生效的客户只出现在报告中，不再计数和处理，避免每次全量同步都产生汇总；
+// 归档成功后飞书中不再有该行，仍在表中说明上次归档失败
+func missingHandled(known KnownCompany, instance Record, missing MissingCustomer) bool {
+	if known.MissingSince == 0 {
+		return false
+	}
+	switch missing.Policy {
+	case MissingPolicyInactive, MissingPolicyStatus:
+		return instance.Fields.ServiceStatus == missing.Status
+	case MissingPolicyArchive:
+		return false
+	}
+	return true
+}
+
+// missingGuard 快照为空或比已知客户骤减时返回原因，此时只报告，避免 Support 返回空数据或部分数据时误处理全部客户
+func missingGuard(snapshot, missing, known int) string {
+	if snapshot == 0 {
+		return "support snapshot is empty"
+	}
+	if known > 0 && float64(missing) > float64(known)*missingGuardRatio {
+		return fmt.Sprintf("%d of %d known customers missing, more than %.0f%%", missing, known, missingGuardRatio*100)
+	}
+	return ""
+}
+
+// handleMissingCustomers 全量同步成功后，找出飞书中仍存在但已不在 Support 快照中的客户并按配置处理
+func (m *MaintenanceToFeishuTask) handleMissingCustomers(snapshot map[string]bool) error {
+	knownBucket := knownCompanyBucket(m.run.Cache)
+	known, err := knownBucket.Map()
+	if err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	seeds := make(map[string]KnownCompany)
+	if len(known) == 0 {
+		for name, instance := range m.feishuRecords {
+			seeds[name] = KnownCompany{Expired: instance.Fields.ServiceStatus == ServiceStatusExpired}
+			known[name] = seeds[name]
+		}
+		log.Printf("Seed %d known customers from feishu", len(seeds))
+	}
+	knownCount := len(known)
+	seen := make(map[string]KnownCompany, len(snapshot))
+	for name, expired := range snapshot {
+		seen[name] = KnownCompany{Expired: expired, LastSeen: now}
+		known[name] = seen[name]
+	}
+	var archived []string
+	// marked 新发现消失的客户，记录时间后后续执行不再计数
+	marked := make(map[string]KnownCompany)
+
+	policy := config.GetConf().MissingCustomerPolicy
+	report := MissingCustomerReport{RunID: m.run.ID, CheckedAt: now, Customers: make([]MissingCustomer, 0)}
+	names := make([]string, 0)
+	for name := range known {
+		if _, exists := snapshot[name]; !exists {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	if reason := missingGuard(len(snapshot), len(names), knownCount); reason != "" && policy != MissingPolicyReport {
+		log.Printf("Skip missing customer policy %s: %s", policy, reason)
+		report.Guarded = reason
+		policy = MissingPolicyReport
+	}
+
+	for _, name := range names {
+		instance, exists := m.feishuRecords[name]
+		if !exists {
+			continue
+		}
+		missing := MissingCustomer{
+			Company: name, RecordID: instance.RecordID, LastSeen: known[name].LastSeen,
+			Policy: policy, Status: missingServiceStatus(policy, known[name]),
+		}
+		report.Customers = append(report.Customers, missing)
+		if missingHandled(known[name], instance, missing) {
+			continue
+		}
+		m.run.Stats.Missing += 1
+		if known[name].MissingSince == 0 {
+			company := known[name]
+			company.MissingSince = now
+			marked[name] = company
+		}
+		if m.run.DryRun {
+			m.run.Report.Add(DiffEntry{
+				Action: DiffActionMissing, Company: name, RecordID: instance.RecordID,
+				Note: fmt.Sprintf("policy %s", policy),
+			})
+			continue
+		}
+		if err = m.applyMissingPolicy(instance, missing); err != nil {
+			log.Printf("Handle missing customer %s failed: %v", name, err)
+			continue
+		}
+		if policy == MissingPolicyArchive {
+			archived = append(archived, name)
+		}
+	}
+	if len(report.Customers) > 0 {
+		log.Printf("%d customers missing from support: %v", len(report.Customers), names)
+	}
+	if m.run.DryRun {
+		return nil
+	}
+	for _, batch := range []map[string]KnownCompany{seeds, marked, seen} {
+		if err = knownBucket.PutBatch(batch); err != nil {
+			return err
+		}
+	}
+	if err = knownBucket.DeleteBatch(archived); err != nil {
+		return err
+	}
+	return missingCustomerBucket(m.run.Cache).Put(latestMissingReport, report)
+}
+
+func (m *MaintenanceToFeishuTask) applyMissingPolicy(instance Record, missing MissingCustomer) error {
+	switch missing.Policy {
+	case MissingPolicyInactive, MissingPolicyStatus:
+		if instance.Fields.ServiceStatus == missing.Status {
+			return nil
+		}
+		fields := map[string]interface{}{`服务状态`: missing.Status}
+		if err := updateFeishuRecordFields(instance.RecordID, fields); err != nil {
+			return err
+		}
+		recordChange(m.run.Cache, ChangeLogEntry{
+			RecordID: instance.RecordID, Company: missing.Company, Action: ChangeActionUpdate,
+			Changes: ChangeSet{{Field: `服务状态`, Old: instance.Fields.ServiceStatus, New: missing.Status}},
+			Task:    m.run.Task, RunID: m.run.ID, Source: ChangeSourceSupport,
+		})
+	case MissingPolicyArchive:
+		if err := archiveFeishuRecord(instance); err != nil {
+			return err
+		}
+		delete(m.feishuRecords, missing.Company)
+		recordChange(m.run.Cache, ChangeLogEntry{
+			RecordID: instance.RecordID, Company: missing.Company, Action: ChangeActionArchive,
+			Changes: archivedChanges(RecordFields(instance)),
+			Task:    m.run.Task, RunID: m.run.ID, Source: ChangeSourceSupport,
+		})
+	}
+	return nil
+}
+
+func archivedChanges(fields map[string]interface{}) ChangeSet {
+	changes := diffFields(nil, fields)
+	for i := range changes {
+		changes[i].Old, changes[i].New = changes[i].New, ""
+	}
+	return changes
+}
+
+// archiveFeishuRecord 先写入归档表再从主表删除，删除失败时归档表中会多一行，可人工清理
+func archiveFeishuRecord(instance Record) error {
+	conf := config.GetConf()
+	client := utils.NewFeishuClient()
+	fields := RecordFields(instance)
+	fields[`编号`] = instance.Fields.Serial
+	fields[`服务状态`] = instance.Fields.ServiceStatus
+	createReq := larkbitable.NewCreateAppTableRecordReqBuilder().
+		AppToken(conf.FeishuTableAppToken).
+		TableId(conf.FeishuArchiveTableID).
+		AppTableRecord(larkbitable.NewAppTableRecordBuilder().Fields(fields).Build()).
+		Build()
+	createResp, err := client.Client.Bitable.V1.AppTableRecord.Create(context.Background(), createReq)
+	if err != nil {
+		return fmt.Errorf("archive failed: %w", err)
+	}
+	if !createResp.Success() {
+		return fmt.Errorf("archive failed: %s", larkcore.Prettify(createResp.CodeError))
+	}
+
+	deleteReq := larkbitable.NewDeleteAppTableRecordReqBuilder().
+		AppToken(conf.FeishuTableAppToken).
+		TableId(conf.FeishuTableID).
+		RecordId(instance.RecordID).
+		Build()
+	deleteResp, err := client.Client.Bitable.V1.AppTableRecord.Delete(context.Background(), deleteReq)
+	if err != nil {
+		return fmt.Errorf("delete archived record failed: %w", err)
+	}
+	if !deleteResp.Success() {
+		return fmt.Errorf("delete archived record failed: %s", larkcore.Prettify(deleteResp.CodeError))
+	}
+	return nil
+}
+
+func (tm *TaskManager) listMissingCustomers(c *gin.Context) {
+	report, found, err := missingCustomerBucket(tm.Cache).Get(latestMissingReport)
+	if !found {
+		report.Customers = make([]MissingCustomer, 0)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": report})
+}
@@ -0,0 +1,38 @@
+package workflow
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"support-workflow/pkg/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (tm *TaskManager) listOutbox(c *gin.Context) {
+	entries, err := tm.Outbox.List(c.DefaultQuery("status", utils.OutboxStatusPending))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": entries, "total": len(entries)})
+}
+
+func (tm *TaskManager) resendOutbox(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid outbox id"})
+		return
+	}
+	err = tm.Outbox.Resend(id)
+	if errors.Is(err, utils.ErrOutboxEntryNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "已重新加入发送队列"})
+}
@@ -0,0 +1,96 @@
+package workflow
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"support-workflow/pkg/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ParkedMaintenanceNamespace 多次写入失败后跳过的客户数据，键为 Support 中的 ID
+const ParkedMaintenanceNamespace = "parked-maintenances"
+
+// ParkedMaintenance 再次拉取到时仍会尝试写入，成功后自动移除；也可以人工修正数据后删除
+type ParkedMaintenance struct {
+	Maintenance Maintenance `json:"maintenance"`
+	Reason      string      `json:"reason"`
+	Attempts    int         `json:"attempts"`
+	CreatedAt   int64       `json:"createdAt"`
+	UpdatedAt   int64       `json:"updatedAt"`
+}
+
+func parkedMaintenanceBucket(cache *utils.Cache) *utils.TypedBucket[ParkedMaintenance] {
+	return utils.NewTypedBucket[ParkedMaintenance](cache, ParkedMaintenanceNamespace)
+}
+
+// parkMaintenance 写入待处理，返回此前是否不在待处理中
+func parkMaintenance(cache *utils.Cache, maintenance Maintenance, attempts int, err error) (bool, error) {
+	bucket := parkedMaintenanceBucket(cache)
+	key := strconv.Itoa(maintenance.ID)
+	item, exists, getErr := bucket.Get(key)
+	if getErr != nil {
+		return false, getErr
+	}
+	now := time.Now().Unix()
+	if !exists {
+		item.CreatedAt = now
+		log.Printf("Park maintenance %v of %s after %d attempts: %v",
+			maintenance.ID, maintenance.Subscription.Customer.Name, attempts, err)
+	}
+	item.Maintenance = maintenance
+	item.Reason = err.Error()
+	item.Attempts = attempts
+	item.UpdatedAt = now
+	return !exists, bucket.Put(key, item)
+}
+
+func unparkMaintenance(cache *utils.Cache, id int) {
+	if err := parkedMaintenanceBucket(cache).Delete(strconv.Itoa(id)); err != nil {
+		log.Printf("Remove parked maintenance %v failed: %v", id, err)
+	}
+}
+
+func (tm *TaskManager) listParkedMaintenances(c *gin.Context) {
+	entries, err := parkedMaintenanceBucket(tm.Cache).List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	items := make([]ParkedMaintenance, 0, len(entries))
+	for _, entry := range entries {
+		items = append(items, entry.Value)
+	}
+	c.JSON(http.StatusOK, gin.H{"data": items, "total": len(items)})
+}
+
+func (tm *TaskManager) deleteParkedMaintenance(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid maintenance id"})
+		return
+	}
+	bucket := parkedMaintenanceBucket(tm.Cache)
+	_, exists, err := bucket.Get(strconv.Itoa(id))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "parked maintenance not found"})
+		return
+	}
+	if err = bucket.Delete(strconv.Itoa(id)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	// 同时清除失败计数，下次拉取到时重新计数
+	if err = writeFailureBucket(tm.Cache).Delete(MaintenanceTaskName + ":" + strconv.Itoa(id)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "删除成功"})
+}
@@ -0,0 +1,186 @@
+package workflow
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"support-workflow/pkg/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// PendingRecordNamespace 待重试的维护记录，键为维护记录 ID
+	PendingRecordNamespace = "pending-records"
+	// CompanyMappingNamespace 人工关联的飞书记录，键为客户名称
+	CompanyMappingNamespace = "company-mapping"
+)
+
+var errFeishuCompanyNotFound = errors.New("not found")
+
+// PendingMaintenanceRecord 飞书中暂时找不到对应客户或多次写入失败的维护记录，每次执行时重试，也可以人工关联后重试
+type PendingMaintenanceRecord struct {
+	Record    MaintenanceRecord `json:"record"`
+	Reason    string            `json:"reason"`
+	Attempts  int               `json:"attempts"`
+	CreatedAt int64             `json:"createdAt"`
+	UpdatedAt int64             `json:"updatedAt"`
+}
+
+type MappingRequest struct {
+	RecordID string `json:"recordId" binding:"required"`
+}
+
+func pendingRecordBucket(cache *utils.Cache) *utils.TypedBucket[PendingMaintenanceRecord] {
+	return utils.NewTypedBucket[PendingMaintenanceRecord](cache, PendingRecordNamespace)
+}
+
+func companyMappingBucket(cache *utils.Cache) *utils.TypedBucket[string] {
+	return utils.NewTypedBucket[string](cache, CompanyMappingNamespace)
+}
+
+func loadCompanyMapping(cache *utils.Cache) (map[string]string, error) {
+	return companyMappingBucket(cache).Map()
+}
+
+// parkPendingRecords 把记录转入待处理队列，返回此前不在队列中的记录数；
+// 最后一页每次都会重新拉取，已在队列中的记录只更新原因，不重复计数
+func parkPendingRecords(cache *utils.Cache, records []MaintenanceRecord, reason string) (int, error) {
+	if len(records) == 0 {
+		return 0, nil
+	}
+	bucket := pendingRecordBucket(cache)
+	keys := make([]string, 0, len(records))
+	for _, record := range records {
+		keys = append(keys, strconv.Itoa(record.ID))
+	}
+	pending, err := bucket.GetBatch(keys)
+	if err != nil {
+		return 0, err
+	}
+	now := time.Now().Unix()
+	parked := 0
+	for i, record := range records {
+		item, exists := pending[keys[i]]
+		if !exists {
+			item = PendingMaintenanceRecord{CreatedAt: now}
+			parked += 1
+			log.Printf("Park maintenance record %v of %s: %s", record.ID, record.CompanyName, reason)
+		}
+		item.Record = record
+		item.Reason = reason
+		item.UpdatedAt = now
+		pending[keys[i]] = item
+	}
+	return parked, bucket.PutBatch(pending)
+}
+
+func (m *MaintenanceRecordToFeishuTask) retryPendingRecords() error {
+	bucket := pendingRecordBucket(m.run.Cache)
+	pending, err := bucket.Map()
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+	failures, err := loadWriteFailures(m.run)
+	if err != nil {
+		return err
+	}
+	now := time.Now().Unix()
+	var synced []string
+	for id, item := range pending {
+		err = m.updateDataToFeishu(item.Record)
+		if err == nil {
+			failures.succeeded(id)
+			synced = append(synced, id)
+			delete(pending, id)
+			log.Printf("Pending maintenance record %v of %s synced", id, item.Record.CompanyName)
+			continue
+		}
+		item.Attempts += 1
+		item.Reason = err.Error()
+		item.UpdatedAt = now
+		pending[id] = item
+	}
+	if m.run.DryRun {
+		return nil
+	}
+	if err = bucket.DeleteBatch(synced); err != nil {
+		return err
+	}
+	return bucket.PutBatch(pending)
+}
+
+func (tm *TaskManager) listPendingRecords(c *gin.Context) {
+	entries, err := pendingRecordBucket(tm.Cache).List()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	items := make([]PendingMaintenanceRecord, 0, len(entries))
+	for _, entry := range entries {
+		items = append(items, entry.Value)
+	}
+	c.JSON(http.StatusOK, gin.H{"data": items, "total": len(items)})
+}
+
+func (tm *TaskManager) mapPendingRecord(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid record id"})
+		return
+	}
+	mappingReq := MappingRequest{}
+	if err = c.ShouldBindJSON(&mappingReq); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	item, exists, err := pendingRecordBucket(tm.Cache).Get(strconv.Itoa(id))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "pending record not found"})
+		return
+	}
+	// 按客户名称关联，同一客户后续的维护记录也会写入该行
+	if err = companyMappingBucket(tm.Cache).Put(item.Record.CompanyName, mappingReq.RecordID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "关联成功，将在下次同步时写入"})
+}
+
+func (tm *TaskManager) deletePendingRecord(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid record id"})
+		return
+	}
+	bucket := pendingRecordBucket(tm.Cache)
+	_, exists, err := bucket.Get(strconv.Itoa(id))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "pending record not found"})
+		return
+	}
+	if err = bucket.Delete(strconv.Itoa(id)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err = writeFailureBucket(tm.Cache).Delete(MaintenanceRecordTaskName + ":" + strconv.Itoa(id)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "删除成功"})
+}
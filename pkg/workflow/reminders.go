@@ -0,0 +1,172 @@
+package workflow
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"support-workflow/pkg/config"
+	"support-workflow/pkg/utils"
+)
+
+const (
+	// ReminderSentNamespace 已发送的提醒，键为记录、日期、档位和负责人，值为发送时间
+	ReminderSentNamespace = "reminders-sent"
+	// 发送超过一年的提醒对应的日期早已过去，不会再命中，到期后由后台清理
+	reminderSentTTL = 365 * 24 * time.Hour
+
+	DiffActionRemind = "remind"
+)
+
+type ReminderItem struct {
+	Company   string
+	Kind      string
+	EndDate   time.Time
+	DaysLeft  int
+	Threshold int
+	key       string
+}
+
+func (ri *ReminderItem) String() string {
+	date := ri.EndDate.In(time.FixedZone("CST", 8*3600)).Format("2006-01-02")
+	return fmt.Sprintf("%s %s %s 到期（剩余 %d 天）", ri.Company, ri.Kind, date, ri.DaysLeft)
+}
+
+// SubscriptionReminderTask 扫描飞书中已同步的订阅和维保结束时间，按销售和交付负责人分组提醒
+type SubscriptionReminderTask struct {
+	run *TaskRun
+
+	feishuRecords map[string]Record
+}
+
+// reminderThreshold 返回剩余天数落入的最小提醒档位，例如剩余 25 天对应 30 天档
+func reminderThreshold(daysLeft int, days []int) (int, bool) {
+	sorted := append([]int(nil), days...)
+	sort.Ints(sorted)
+	for _, threshold := range sorted {
+		if daysLeft <= threshold {
+			return threshold, true
+		}
+	}
+	return 0, false
+}
+
+// reminderOwners 销售和交付负责人去重，都为空时归入无负责人分组
+func reminderOwners(record Record) []string {
+	var owners []string
+	for _, owner := range []string{record.Fields.SaleUser, record.Fields.CreatorName} {
+		if owner == "" || (len(owners) > 0 && owners[0] == owner) {
+			continue
+		}
+		owners = append(owners, owner)
+	}
+	if len(owners) == 0 {
+		owners = append(owners, "")
+	}
+	return owners
+}
+
+func (t *SubscriptionReminderTask) collect(now time.Time, sent map[string]int64) map[string][]ReminderItem {
+	conf := config.GetConf()
+	groups := make(map[string][]ReminderItem)
+	for name, record := range t.feishuRecords {
+		dates := []struct {
+			kind    string
+			endDate int
+		}{
+			{"订阅", record.Fields.EndDate},
+			{"维保", record.Fields.SupportEndDate},
+		}
+		for _, date := range dates {
+			if date.endDate == 0 {
+				continue
+			}
+			endDate := time.UnixMilli(int64(date.endDate))
+			daysLeft := int(math.Ceil(endDate.Sub(now).Hours() / 24))
+			if daysLeft < 0 {
+				continue
+			}
+			threshold, matched := reminderThreshold(daysLeft, conf.ReminderDays)
+			if !matched {
+				continue
+			}
+			owners := reminderOwners(record)
+			for _, owner := range owners {
+				key := fmt.Sprintf("%s|%s|%d|%d|%s", record.RecordID, date.kind, date.endDate, threshold, owner)
+				if _, exists := sent[key]; exists {
+					continue
+				}
+				groups[owner] = append(groups[owner], ReminderItem{
+					Company: name, Kind: date.kind, EndDate: endDate,
+					DaysLeft: daysLeft, Threshold: threshold, key: key,
+				})
+			}
+		}
+	}
+	return groups
+}
+
+func reminderContent(owner string, items []ReminderItem) string {
+	sort.Slice(items, func(i, j int) bool { return items[i].DaysLeft < items[j].DaysLeft })
+	var builder strings.Builder
+	if owner == "" {
+		builder.WriteString("【到期提醒】以下客户即将到期，暂无负责人，请认领跟进：")
+	} else {
+		builder.WriteString(fmt.Sprintf("【到期提醒】%s，以下客户即将到期，请及时跟进：", owner))
+	}
+	for i, item := range items {
+		builder.WriteString(fmt.Sprintf("\n%d. %s", i+1, item.String()))
+	}
+	return builder.String()
+}
+
+func (t *SubscriptionReminderTask) Execute(run *TaskRun) error {
+	t.run = run
+	if err := loadFeishuRecords(t.feishuRecords); err != nil {
+		return err
+	}
+	sentBucket := utils.NewTypedBucket[int64](run.Cache, ReminderSentNamespace)
+	sent, err := sentBucket.Map()
+	if err != nil {
+		return err
+	}
+	sentNow := make(map[string]int64)
+
+	now := time.Now()
+	conf := config.GetConf()
+	groups := t.collect(now, sent)
+	owners := make([]string, 0, len(groups))
+	for owner := range groups {
+		owners = append(owners, owner)
+	}
+	sort.Strings(owners)
+	for _, owner := range owners {
+		items := groups[owner]
+		content := reminderContent(owner, items)
+		if run.DryRun {
+			run.Report.Add(DiffEntry{Action: DiffActionRemind, Company: owner, Note: content})
+			continue
+		}
+		// viper 读取配置时会把键转为小写
+		var mobiles []string
+		if mobile, exists := conf.ReminderContacts[strings.ToLower(owner)]; exists {
+			mobiles = append(mobiles, mobile)
+		}
+		msg := utils.Message{Content: content, MentionMobiles: mobiles}
+		if err = run.Outbox.Enqueue(utils.EventReminder, msg); err != nil {
+			log.Printf("Send reminder to %s failed: %v", owner, err)
+			continue
+		}
+		for _, item := range items {
+			sentNow[item.key] = now.Unix()
+		}
+		log.Printf("Send %d reminders to %s", len(items), owner)
+	}
+	if run.DryRun {
+		return nil
+	}
+	return sentBucket.PutBatchTTL(sentNow, reminderSentTTL)
+}
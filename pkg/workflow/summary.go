@@ -0,0 +1,249 @@
+package workflow
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"support-workflow/pkg/config"
+	"support-workflow/pkg/utils"
+)
+
+const (
+	// SyncDigestNamespace 累计中的同步汇总，只有 syncDigestKey 一个键
+	SyncDigestNamespace = "sync-digest"
+	syncDigestKey       = "current"
+
+	// maxSummaryErrors 通知中最多展示的错误条数，相同的错误只保留一条
+	maxSummaryErrors = 5
+)
+
+// summaryTasks 需要发送同步汇总的任务，到期提醒本身就是通知，不再汇总
+var summaryTasks = map[string]bool{
+	MaintenanceTaskName:       true,
+	MaintenanceRecordTaskName: true,
+}
+
+// RunStats 一次执行中各类写入的数量及失败原因
+type RunStats struct {
+	Created   int      `json:"created"`
+	Updated   int      `json:"updated"`
+	Unchanged int      `json:"unchanged"`
+	Appended  int      `json:"appended"`
+	Parked    int      `json:"parked"`
+	Missing   int      `json:"missing"`
+	Failed    int      `json:"failed"`
+	Errors    []string `json:"errors,omitempty"`
+}
+
+// Fail 记录一次写入失败
+func (s *RunStats) Fail(err error) {
+	s.Failed += 1
+	s.AddError(err)
+}
+
+func (s *RunStats) AddError(err error) {
+	if err != nil {
+		s.addErrorMessage(err.Error())
+	}
+}
+
+func (s *RunStats) addErrorMessage(message string) {
+	if len(s.Errors) >= maxSummaryErrors {
+		return
+	}
+	for _, existing := range s.Errors {
+		if existing == message {
+			return
+		}
+	}
+	s.Errors = append(s.Errors, message)
+}
+
+// Changed 是否有写入，全部为未变化或只有失败的执行不发送汇总；
+// 失败由任务告警按签名去重和重复间隔通知，避免服务不可用时每次执行都发送相同的错误
+func (s *RunStats) Changed() bool {
+	return s.Created+s.Updated+s.Appended+s.Parked+s.Missing > 0
+}
+
+func (s *RunStats) Merge(other RunStats) {
+	s.Created += other.Created
+	s.Updated += other.Updated
+	s.Unchanged += other.Unchanged
+	s.Appended += other.Appended
+	s.Parked += other.Parked
+	s.Missing += other.Missing
+	s.Failed += other.Failed
+	for _, message := range other.Errors {
+		s.addErrorMessage(message)
+	}
+}
+
+// TaskDigest 汇总周期内某个任务的累计结果
+type TaskDigest struct {
+	Runs  int      `json:"runs"`
+	Stats RunStats `json:"stats"`
+}
+
+// SyncDigest 尚未发送的汇总，保存在缓存中，重启后继续累计
+type SyncDigest struct {
+	StartedAt int64                  `json:"startedAt"`
+	Tasks     map[string]*TaskDigest `json:"tasks"`
+}
+
+func digestInterval() time.Duration {
+	value := config.GetConf().SyncDigestInterval
+	if value == "" {
+		return 0
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("Invalid SYNC_DIGEST_INTERVAL %q, send summary per run: %v", value, err)
+		return 0
+	}
+	return interval
+}
+
+// summarize 在同步任务执行后发送汇总，失败原因随写入结果一起展示；配置了汇总周期时先累计，到期后合并发送。
+// 通知失败只记录日志，累计的结果保留到下次再发
+func (tm *TaskManager) summarize(run *TaskRun, err error) {
+	if run.DryRun || !summaryTasks[run.Task] {
+		return
+	}
+	// 逐条写入失败时任务错误只是失败条数的汇总，不再重复展示
+	if run.Stats.Failed == 0 {
+		run.Stats.AddError(err)
+	}
+	now := time.Now()
+	interval := digestInterval()
+	if interval <= 0 {
+		if !run.Stats.Changed() {
+			return
+		}
+		digest := SyncDigest{
+			StartedAt: run.StartedAt.Unix(),
+			Tasks:     map[string]*TaskDigest{run.Task: {Runs: 1, Stats: run.Stats}},
+		}
+		if err = tm.Outbox.Enqueue(utils.EventSync, digest.Message(now)); err != nil {
+			log.Printf("Send sync summary of %s failed: %v", run.ID, err)
+		}
+		return
+	}
+
+	digests := utils.NewTypedBucket[SyncDigest](tm.Cache, SyncDigestNamespace)
+	digest, _, err := digests.Get(syncDigestKey)
+	if err != nil {
+		log.Printf("Load sync digest failed: %v", err)
+		return
+	}
+	if digest.StartedAt == 0 {
+		digest.StartedAt = run.StartedAt.Unix()
+	}
+	if digest.Tasks == nil {
+		digest.Tasks = make(map[string]*TaskDigest)
+	}
+	taskDigest, exists := digest.Tasks[run.Task]
+	if !exists {
+		taskDigest = &TaskDigest{}
+		digest.Tasks[run.Task] = taskDigest
+	}
+	taskDigest.Runs += 1
+	taskDigest.Stats.Merge(run.Stats)
+
+	if now.Sub(time.Unix(digest.StartedAt, 0)) >= interval {
+		if !digest.Changed() {
+			digest = SyncDigest{Tasks: make(map[string]*TaskDigest)}
+		} else if err = tm.Outbox.Enqueue(utils.EventSync, digest.Message(now)); err != nil {
+			log.Printf("Send sync digest failed: %v", err)
+		} else {
+			digest = SyncDigest{Tasks: make(map[string]*TaskDigest)}
+		}
+	}
+	if err = digests.Put(syncDigestKey, digest); err != nil {
+		log.Printf("Save sync digest failed: %v", err)
+	}
+}
+
+func (d *SyncDigest) Changed() bool {
+	for _, taskDigest := range d.Tasks {
+		if taskDigest.Stats.Changed() {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *SyncDigest) taskNames() []string {
+	names := make([]string, 0, len(d.Tasks))
+	for name := range d.Tasks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Message 生成汇总通知，企业微信使用 markdown，其他渠道使用字段列表
+func (d *SyncDigest) Message(now time.Time) utils.Message {
+	conf := config.GetConf()
+	period := fmt.Sprintf("%s ~ %s",
+		time.Unix(d.StartedAt, 0).Format("2006-01-02 15:04"), now.Format("2006-01-02 15:04"))
+	msg := utils.Message{Title: "Support 门户数据同步汇总", Content: period, URL: conf.FeishuTableURL}
+
+	var markdown strings.Builder
+	markdown.WriteString("**Support 门户数据同步汇总**\n")
+	markdown.WriteString(fmt.Sprintf(`<font color="comment">%s</font>`, period))
+	for _, name := range d.taskNames() {
+		taskDigest := d.Tasks[name]
+		stats := taskDigest.Stats
+		counts := stats.counts(name)
+		var parts []string
+		for _, count := range counts {
+			parts = append(parts, count.Key+" "+count.Value)
+		}
+		msg.Fields = append(msg.Fields, utils.MessageField{
+			Key:   taskTitles[name],
+			Value: fmt.Sprintf("执行 %d 次，%s", taskDigest.Runs, strings.Join(parts, "，")),
+		})
+
+		markdown.WriteString(fmt.Sprintf("\n\n**%s**（执行 %d 次）", taskTitles[name], taskDigest.Runs))
+		for _, count := range counts {
+			color := "info"
+			if count.Key == "失败" && stats.Failed > 0 {
+				color = "warning"
+			}
+			markdown.WriteString(fmt.Sprintf("\n> %s: <font color=\"%s\">%s</font>", count.Key, color, count.Value))
+		}
+		for _, message := range stats.Errors {
+			msg.Fields = append(msg.Fields, utils.MessageField{Key: "错误", Value: message})
+			markdown.WriteString(fmt.Sprintf("\n> <font color=\"warning\">%s</font>", message))
+		}
+	}
+	if conf.FeishuTableURL != "" {
+		markdown.WriteString(fmt.Sprintf("\n\n[查看飞书表格](%s)", conf.FeishuTableURL))
+	}
+	msg.Wecom = utils.NewWecomMarkdown(markdown.String())
+	return msg
+}
+
+// counts 各任务关心的计数不同，客户同步没有追加，维护记录同步没有新建和更新
+func (s *RunStats) counts(task string) []utils.MessageField {
+	var counts []utils.MessageField
+	add := func(key string, value int) {
+		counts = append(counts, utils.MessageField{Key: key, Value: strconv.Itoa(value)})
+	}
+	switch task {
+	case MaintenanceTaskName:
+		add("新建", s.Created)
+		add("更新", s.Updated)
+		add("未变化", s.Unchanged)
+		add("消失", s.Missing)
+	case MaintenanceRecordTaskName:
+		add("追加", s.Appended)
+		add("待处理", s.Parked)
+	}
+	add("失败", s.Failed)
+	return counts
+}
@@ -0,0 +1,175 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"support-workflow/pkg/config"
+	"support-workflow/pkg/utils"
+
+	larkcore "github.com/larksuite/oapi-sdk-go/v3/core"
+	larkcontact "github.com/larksuite/oapi-sdk-go/v3/service/contact/v3"
+	larkim "github.com/larksuite/oapi-sdk-go/v3/service/im/v1"
+)
+
+const (
+	ChatProviderFeishu = "feishu"
+	ChatProviderWecom  = "wecom"
+)
+
+// supportChatFields 各渠道群 ID 回写到飞书表格的列
+var supportChatFields = map[string]string{
+	ChatProviderFeishu: `飞书群ID`,
+	ChatProviderWecom:  `企业微信群ID`,
+}
+
+// supportChatMobiles 配置的固定成员加上销售、交付负责人，负责人通过 REMINDER_CONTACTS 找到手机号
+func supportChatMobiles(owners []string) []string {
+	conf := config.GetConf()
+	var mobiles []string
+	seen := make(map[string]bool)
+	add := func(mobile string) {
+		mobile = strings.TrimSpace(mobile)
+		if mobile == "" || seen[mobile] {
+			return
+		}
+		seen[mobile] = true
+		mobiles = append(mobiles, mobile)
+	}
+	for _, owner := range owners {
+		if owner == "" {
+			continue
+		}
+		// viper 读取配置时会把键转为小写
+		mobile, exists := conf.ReminderContacts[strings.ToLower(owner)]
+		if !exists {
+			log.Printf("Support chat owner %s has no mobile in REMINDER_CONTACTS, skipped", owner)
+			continue
+		}
+		add(mobile)
+	}
+	for _, mobile := range conf.SupportChatMembers {
+		add(mobile)
+	}
+	return mobiles
+}
+
+// createSupportChats 按 SUPPORT_CHAT_PROVIDERS 创建支持群并把群 ID 写回飞书行，
+// 部分渠道失败时已创建的群 ID 仍会写回，返回的错误包含所有失败的渠道
+func createSupportChats(cache *utils.Cache, recordID, company, chatName string, owners []string) (map[string]string, error) {
+	conf := config.GetConf()
+	mobiles := supportChatMobiles(owners)
+	chatIDs := make(map[string]string)
+	var errs []error
+	for _, provider := range conf.SupportChatProviders {
+		var chatID string
+		var err error
+		switch provider {
+		case ChatProviderFeishu:
+			chatID, err = createFeishuChat(recordID, chatName, mobiles)
+		case ChatProviderWecom:
+			chatID, err = createWecomChat(chatName, mobiles)
+		default:
+			err = fmt.Errorf("unknown chat provider %q", provider)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", provider, err))
+			continue
+		}
+		chatIDs[provider] = chatID
+		log.Printf("Create %s support chat %s for %s: %s", provider, chatName, company, chatID)
+	}
+	if len(chatIDs) == 0 {
+		return chatIDs, errors.Join(errs...)
+	}
+
+	fields := make(map[string]interface{})
+	for provider, chatID := range chatIDs {
+		fields[supportChatFields[provider]] = chatID
+	}
+	if err := updateFeishuRecordFields(recordID, fields); err != nil {
+		errs = append(errs, fmt.Errorf("write chat id back failed: %w", err))
+	} else {
+		recordChange(cache, ChangeLogEntry{
+			RecordID: recordID, Company: company, Action: ChangeActionUpdate,
+			Changes: diffFields(nil, fields),
+			Task:    "新客户登记", RunID: newRunID(), Source: ChangeSourceWebForm,
+		})
+	}
+	return chatIDs, errors.Join(errs...)
+}
+
+// feishuUserIDs 通过手机号查询飞书 open_id，查不到的手机号跳过
+func feishuUserIDs(client *utils.FeishuClient, mobiles []string) ([]string, error) {
+	if len(mobiles) == 0 {
+		return nil, nil
+	}
+	req := larkcontact.NewBatchGetIdUserReqBuilder().
+		UserIdType("open_id").
+		Body(larkcontact.NewBatchGetIdUserReqBodyBuilder().Mobiles(mobiles).Build()).
+		Build()
+	resp, err := client.Client.Contact.V3.User.BatchGetId(context.Background(), req)
+	if err != nil {
+		return nil, err
+	}
+	if !resp.Success() {
+		return nil, fmt.Errorf("get feishu users failed: %s", larkcore.Prettify(resp.CodeError))
+	}
+	var userIDs []string
+	for _, user := range resp.Data.UserList {
+		if user.UserId == nil {
+			log.Printf("Feishu user of mobile %s not found", larkcore.StringValue(user.Mobile))
+			continue
+		}
+		userIDs = append(userIDs, *user.UserId)
+	}
+	return userIDs, nil
+}
+
+// createFeishuChat 以行 ID 作为去重标识，重复提交时飞书不会重复建群
+func createFeishuChat(recordID, chatName string, mobiles []string) (string, error) {
+	client := utils.NewFeishuClient()
+	userIDs, err := feishuUserIDs(client, mobiles)
+	if err != nil {
+		return "", err
+	}
+	body := larkim.NewCreateChatReqBodyBuilder().
+		Name(chatName).
+		UserIdList(userIDs).
+		Build()
+	req := larkim.NewCreateChatReqBuilder().
+		UserIdType("open_id").
+		SetBotManager(true).
+		Uuid("support-chat-" + recordID).
+		Body(body).
+		Build()
+	resp, err := client.Client.Im.V1.Chat.Create(context.Background(), req)
+	if err != nil {
+		return "", err
+	}
+	if !resp.Success() {
+		return "", fmt.Errorf("create feishu chat failed: %s", larkcore.Prettify(resp.CodeError))
+	}
+	return larkcore.StringValue(resp.Data.ChatId), nil
+}
+
+func createWecomChat(chatName string, mobiles []string) (string, error) {
+	client := utils.NewWecomAppClient()
+	var userIDs []string
+	for _, mobile := range mobiles {
+		userID, err := client.GetUserIDByMobile(mobile)
+		if err != nil {
+			log.Printf("Skip wecom member: %v", err)
+			continue
+		}
+		userIDs = append(userIDs, userID)
+	}
+	owner := ""
+	if len(userIDs) > 0 {
+		owner = userIDs[0]
+	}
+	return client.CreateAppChat(chatName, owner, userIDs)
+}
@@ -2,7 +2,6 @@ package workflow
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"log"
 	"strconv"
@@ -11,6 +10,7 @@ import (
 	"support-workflow/pkg/config"
 	"support-workflow/pkg/utils"
 
+	larkcore "github.com/larksuite/oapi-sdk-go/v3/core"
 	larkbitable "github.com/larksuite/oapi-sdk-go/v3/service/bitable/v1"
 )
 
@@ -59,35 +59,23 @@ type Maintenance struct {
 	OtherInfo    OtherInfo    `json:"content"`
 }
 
-func (m *Maintenance) Same(other *Maintenance) bool {
-	if other.CreatorName != m.CreatorName {
-		return false
+// FeishuFields 客户数据映射到飞书表格的字段，新建、更新和变更比较都以此为准
+func (m *Maintenance) FeishuFields(serial int) map[string]interface{} {
+	abbreviatedName := m.Subscription.Customer.AbbreviatedName
+	return map[string]interface{}{
+		`最终客户名称`: fmt.Sprintf("%v-%s", serial, abbreviatedName),
+		`客户全称`:   m.Subscription.Customer.Name,
+		`简称`:     abbreviatedName,
+		`销售`:     m.Subscription.SalesUser.Name,
+		`交付负责人`:  m.CreatorName,
+		`系统版本`:   m.Version,
+		`部署架构`:   m.DeployArch,
+		`订阅类型`:   m.Subscription.ServiceType,
+		`规格`:     strconv.Itoa(m.Subscription.Amount),
+		`订阅开始时间`: m.Subscription.StartDate,
+		`订阅结束时间`: m.Subscription.EndDate,
+		`维保结束时间`: m.Subscription.SupportEndDate,
 	}
-	if other.Version != m.Version {
-		return false
-	}
-	if other.Subscription.Amount != m.Subscription.Amount {
-		return false
-	}
-	if other.Subscription.StartDate != m.Subscription.StartDate {
-		return false
-	}
-	if other.Subscription.EndDate != m.Subscription.EndDate {
-		return false
-	}
-	if other.Subscription.SalesUser.Name != m.Subscription.SalesUser.Name {
-		return false
-	}
-	if other.Subscription.Customer.Name != m.Subscription.Customer.Name {
-		return false
-	}
-	if other.Subscription.Customer.AbbreviatedName != m.Subscription.Customer.AbbreviatedName {
-		return false
-	}
-	if other.Subscription.SupportEndDate != m.Subscription.SupportEndDate {
-		return false
-	}
-	return true
 }
 
 func (m *Maintenance) FitData() {
@@ -105,77 +93,172 @@ type MaintenanceResponse struct {
 	Marker int           `json:"marker"`
 }
 
+const (
+	MaintenanceLastMarker   = "MaintenanceLastMarker"
+	MaintenanceLastFullSync = "MaintenanceLastFullSync"
+
+	// SyncStateNamespace 同步任务的其它状态，例如上次全量同步的时间
+	SyncStateNamespace = "sync-state"
+)
+
+func syncStateBucket(cache *utils.Cache) *utils.TypedBucket[int64] {
+	return utils.NewTypedBucket[int64](cache, SyncStateNamespace)
+}
+
 type MaintenanceToFeishuTask struct {
 	productName  string
 	executeTimes int
 	maxValue     int
+	run          *TaskRun
+	// snapshot 全量同步时 Support 中出现的客户及其是否过期
+	snapshot map[string]bool
 
 	feishuRecords map[string]Record
 }
 
-func (m *MaintenanceToFeishuTask) getMaintenances() ([]Maintenance, error) {
-	var maintenances []Maintenance
+func (m *MaintenanceToFeishuTask) getMaintenances(client utils.SupportClient, marker int) (*MaintenanceResponse, error) {
 	var maintenanceResp MaintenanceResponse
+	url := fmt.Sprintf("/openapi/v1/bi/maintenances?region=northern&product=%v&max=%v", m.productName, m.maxValue)
+	if marker != 0 {
+		url += fmt.Sprintf("&marker=%v", marker)
+	}
+	log.Printf("URL: %s", url)
+	err := client.Get(url, &maintenanceResp)
+	if err != nil {
+		return nil, err
+	}
+	m.executeTimes += 1
+	log.Printf("Len: %d", len(maintenanceResp.Data))
+	return &maintenanceResp, nil
+}
+
+// needFullSync 增量同步只能拿到新增的客户，已有客户的变更依赖每天定时的全量同步
+func (m *MaintenanceToFeishuTask) needFullSync(now time.Time) (bool, error) {
+	if m.run.FullSync {
+		return true, nil
+	}
+	lastFullSync, found, err := syncStateBucket(m.run.Cache).Get(MaintenanceLastFullSync)
+	if err != nil {
+		return false, err
+	}
+	if !found {
+		return true, nil
+	}
+	scheduled, err := time.ParseInLocation("15:04", config.GetConf().MaintenanceFullSyncTime, now.Location())
+	if err != nil {
+		return false, fmt.Errorf("invalid MAINTENANCE_FULL_SYNC_TIME: %w", err)
+	}
+	scheduledAt := time.Date(
+		now.Year(), now.Month(), now.Day(), scheduled.Hour(), scheduled.Minute(), 0, 0, now.Location(),
+	)
+	if now.Before(scheduledAt) {
+		scheduledAt = scheduledAt.AddDate(0, 0, -1)
+	}
+	return lastFullSync < scheduledAt.Unix(), nil
+}
+
+// writeMaintenances 写入一页客户数据；同一条目连续失败 maxWriteAttempts 次后转入待处理，
+// 避免一条坏数据使整页一直无法提交、全量同步每次都重新执行
+func (m *MaintenanceToFeishuTask) writeMaintenances(maintenances []Maintenance) error {
+	failures, err := loadWriteFailures(m.run)
+	if err != nil {
+		return fmt.Errorf("load write failures failed: %w", err)
+	}
+	var failed int
+	var lastErr error
+	for _, maintenance := range maintenances {
+		maintenance.FitData()
+		if m.snapshot != nil && maintenance.Subscription.Customer.Name != "" {
+			m.snapshot[maintenance.Subscription.Customer.Name] = maintenance.Subscription.Expired
+		}
+		id := strconv.Itoa(maintenance.ID)
+		err = m.updateOrCreateFeishuRecord(maintenance)
+		if err == nil {
+			if failures.succeeded(id) {
+				unparkMaintenance(m.run.Cache, maintenance.ID)
+			}
+			continue
+		}
+		log.Printf("Error updating feishu maintenance: %v", err)
+		if attempts, giveUp := failures.failed(id, err); giveUp {
+			parked, parkErr := parkMaintenance(m.run.Cache, maintenance, attempts, err)
+			if parkErr == nil {
+				if parked {
+					m.run.Stats.Parked += 1
+				}
+				continue
+			}
+			log.Printf("Park maintenance %v failed: %v", maintenance.ID, parkErr)
+		}
+		m.run.Stats.Fail(err)
+		failed += 1
+		lastErr = err
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d maintenances failed, last error: %w", failed, len(maintenances), lastErr)
+	}
+	return nil
+}
+
+func (m *MaintenanceToFeishuTask) syncMaintenances(full bool) error {
+	var marker int
+	var err error
 	client := utils.NewSupportClient()
-	for {
-		url := fmt.Sprintf("/openapi/v1/bi/maintenances?region=northern&product=%v&max=%v", m.productName, m.maxValue)
-		if maintenanceResp.Marker != 0 {
-			url += fmt.Sprintf("&marker=%v", maintenanceResp.Marker)
+	if !full {
+		var saved bool
+		if marker, saved, err = loadCheckpoint(m.run.Cache, MaintenanceLastMarker); err != nil {
+			return fmt.Errorf("load checkpoint failed: %w", err)
 		}
-		fmt.Println("URL: ", url)
-		err := client.Get(url, &maintenanceResp)
+		if !saved {
+			log.Printf("[%s] 没有保存的 marker，从头同步", taskTitles[m.run.Task])
+		}
+	}
+	for {
+		maintenanceResp, err := m.getMaintenances(client, marker)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		m.executeTimes += 1
-		maintenances = append(maintenances, maintenanceResp.Data...)
-		fmt.Println("Len: ", len(maintenances))
+		if err = m.writeMaintenances(maintenanceResp.Data); err != nil {
+			return fmt.Errorf("page at marker %v not committed: %w", marker, err)
+		}
+		// 与维护记录一致，保留最后一页的起始 marker，下次增量从这一页开始
 		if maintenanceResp.Marker == -1 {
 			break
 		}
+		marker = maintenanceResp.Marker
+		if m.run.DryRun {
+			continue
+		}
+		if err = saveCheckpoint(m.run.Cache, MaintenanceLastMarker, marker); err != nil {
+			return fmt.Errorf("save checkpoint failed: %w", err)
+		}
 	}
-	return maintenances, nil
+	return nil
 }
 
-func (m *MaintenanceToFeishuTask) getFeishuMaintenance(companyName string) (*Maintenance, error) {
-	var instance Record
-	var exists bool
-	if instance, exists = m.feishuRecords[companyName]; !exists {
-		instance = Record{
-			Fields: Fields{
-				Serial: 0,
-				CompanyFullName: []TypeTextField{
-					{Type: "", Text: companyName},
-				},
-			},
-		}
+// RecordFields 飞书表格中已有的字段值，与 Maintenance.FeishuFields 对应
+func RecordFields(record Record) map[string]interface{} {
+	var companyName, version string
+	if len(record.Fields.CompanyFullName) > 0 {
+		companyName = record.Fields.CompanyFullName[0].Text
+	}
+	for _, item := range record.Fields.ProductVersion {
+		version += item.Text
+	}
+	return map[string]interface{}{
+		`最终客户名称`: string(record.Fields.FinalName),
+		`客户全称`:   companyName,
+		`简称`:     record.Fields.AbbreviatedName,
+		`销售`:     record.Fields.SaleUser,
+		`交付负责人`:  record.Fields.CreatorName,
+		`系统版本`:   version,
+		`部署架构`:   string(record.Fields.DeployArch),
+		`订阅类型`:   string(record.Fields.ServiceType),
+		`规格`:     record.Fields.Amount,
+		`订阅开始时间`: record.Fields.StartDate,
+		`订阅结束时间`: record.Fields.EndDate,
+		`维保结束时间`: record.Fields.SupportEndDate,
 	}
-
-	var version string
-	amount, _ := strconv.Atoi(instance.Fields.Amount)
-	if len(instance.Fields.ProductVersion) > 0 {
-		version = instance.Fields.ProductVersion[0].Text
-	}
-	maintenance := &Maintenance{
-		Serial:      instance.Fields.Serial,
-		RecordID:    instance.RecordID,
-		CreatorName: instance.Fields.CreatorName,
-		Version:     version,
-		Subscription: Subscription{
-			StartDate: instance.Fields.StartDate,
-			EndDate:   instance.Fields.EndDate,
-			Amount:    amount,
-			SalesUser: SalesUser{
-				Name: instance.Fields.SaleUser,
-			},
-			Customer: Customer{
-				Name:            instance.Fields.CompanyFullName[0].Text,
-				AbbreviatedName: instance.Fields.AbbreviatedName,
-			},
-			SupportEndDate: instance.Fields.SupportEndDate,
-		},
-	}
-	return maintenance, nil
 }
 
 func (m *MaintenanceToFeishuTask) updateOrCreateFeishuRecord(maintenance Maintenance) error {
@@ -185,28 +268,15 @@ func (m *MaintenanceToFeishuTask) updateOrCreateFeishuRecord(maintenance Mainten
 	if companyName == "" {
 		return nil
 	}
-	feishuMaintenance, err := m.getFeishuMaintenance(companyName)
-	if err != nil {
-		return fmt.Errorf("update %s failed: %w", companyName, err)
-	}
 
-	abbreviatedName := maintenance.Subscription.Customer.AbbreviatedName
-	record := larkbitable.NewAppTableRecordBuilder().
-		Fields(map[string]interface{}{
-			`最终客户名称`: fmt.Sprintf("%v-%s", feishuMaintenance.Serial, abbreviatedName),
-			`客户全称`:   companyName,
-			`简称`:     abbreviatedName,
-			`销售`:     maintenance.Subscription.SalesUser.Name,
-			`交付负责人`:  maintenance.CreatorName,
-			`系统版本`:   maintenance.Version,
-			`部署架构`:   maintenance.DeployArch,
-			`订阅类型`:   maintenance.Subscription.ServiceType,
-			`规格`:     strconv.Itoa(maintenance.Subscription.Amount),
-			`订阅开始时间`: maintenance.Subscription.StartDate,
-			`订阅结束时间`: maintenance.Subscription.EndDate,
-			`维保结束时间`: maintenance.Subscription.SupportEndDate,
-		}).Build()
-	if feishuMaintenance.RecordID == "" {
+	instance, exists := m.feishuRecords[companyName]
+	fields := maintenance.FeishuFields(instance.Fields.Serial)
+	if !exists && m.run.DryRun {
+		m.run.Report.Add(DiffEntry{Action: DiffActionCreate, Company: companyName, Changes: diffFields(nil, fields)})
+		return nil
+	}
+	if !exists {
+		record := larkbitable.NewAppTableRecordBuilder().Fields(fields).Build()
 		req := larkbitable.NewCreateAppTableRecordReqBuilder().
 			AppToken(conf.FeishuTableAppToken).
 			TableId(conf.FeishuTableID).
@@ -219,107 +289,128 @@ func (m *MaintenanceToFeishuTask) updateOrCreateFeishuRecord(maintenance Mainten
 			return fmt.Errorf("error response: %s", resp.RawBody)
 		}
 		log.Printf("Create maintenance %v success", companyName)
-	} else if !maintenance.Same(feishuMaintenance) {
-		req := larkbitable.NewUpdateAppTableRecordReqBuilder().
-			AppToken(conf.FeishuTableAppToken).
-			TableId(conf.FeishuTableID).
-			RecordId(feishuMaintenance.RecordID).
-			AppTableRecord(record).Build()
-
-		resp, err := client.Client.Bitable.V1.AppTableRecord.Update(context.Background(), req)
-		if err != nil {
-			return fmt.Errorf("update %s failed: %w", companyName, err)
+		m.run.Stats.Created += 1
+		recordID := larkcore.StringValue(resp.Data.Record.RecordId)
+		recordChange(m.run.Cache, ChangeLogEntry{
+			RecordID: recordID, Company: companyName,
+			Action: ChangeActionCreate, Changes: diffFields(nil, fields),
+			Task: m.run.Task, RunID: m.run.ID, Source: ChangeSourceSupport,
+		})
+		if err = markWritten(m.run.Cache, recordID, normalizeFields(fields)); err != nil {
+			log.Printf("Save write state of %s failed: %v", companyName, err)
 		}
+		return nil
+	}
 
-		if !resp.Success() {
-			return fmt.Errorf("error response: %s", resp.RawBody)
+	current := RecordFields(instance)
+	changes := diffFields(current, fields)
+	if !m.run.DryRun {
+		if err := seedWriteState(m.run.Cache, instance.RecordID, current, fields); err != nil {
+			log.Printf("Seed write state of %s failed: %v", companyName, err)
+		}
+	}
+	if len(changes) == 0 {
+		m.run.Stats.Unchanged += 1
+		return nil
+	}
+	changes, reviews, err := applyConflictPolicy(m.run.Cache, instance.RecordID, companyName, changes, fields)
+	if err != nil {
+		return fmt.Errorf("apply conflict policy of %s failed: %w", companyName, err)
+	}
+	if m.run.DryRun {
+		for _, review := range reviews {
+			m.run.Report.Add(DiffEntry{
+				Action: DiffActionReview, Company: companyName, RecordID: instance.RecordID,
+				Changes: ChangeSet{{Field: review.Field, Old: review.FeishuValue, New: review.SourceValue}},
+			})
+		}
+		if len(changes) > 0 {
+			m.run.Report.Add(DiffEntry{
+				Action: DiffActionUpdate, Company: companyName, RecordID: instance.RecordID, Changes: changes,
+			})
 		}
-		log.Printf("Update maintenance %v success", companyName)
+		return nil
+	}
+	if err = flagForReview(m.run.Cache, reviews); err != nil {
+		return fmt.Errorf("flag %s for review failed: %w", companyName, err)
+	}
+	if len(changes) == 0 {
+		m.run.Stats.Unchanged += 1
+		return nil
+	}
+	if err = updateFeishuRecordFields(instance.RecordID, changes.Values(fields)); err != nil {
+		return fmt.Errorf("update %s failed: %w", companyName, err)
+	}
+	log.Printf("Update maintenance %v success, changed: %s", companyName, changes)
+	m.run.Stats.Updated += 1
+	recordChange(m.run.Cache, ChangeLogEntry{
+		RecordID: instance.RecordID, Company: companyName,
+		Action: ChangeActionUpdate, Changes: changes,
+		Task: m.run.Task, RunID: m.run.ID, Source: ChangeSourceSupport,
+	})
+	if err = markWritten(m.run.Cache, instance.RecordID, changes.NewValues()); err != nil {
+		log.Printf("Save write state of %s failed: %v", companyName, err)
 	}
 	return nil
 }
 
-func (m *MaintenanceToFeishuTask) InitResources() error {
-	// 飞书表格一次性获取，API 有限额
-	pageToken := ""
+func updateFeishuRecordFields(recordID string, fields map[string]interface{}) error {
 	conf := config.GetConf()
 	client := utils.NewFeishuClient()
-	for {
-		req := larkbitable.NewSearchAppTableRecordReqBuilder().
-			AppToken(conf.FeishuTableAppToken).
-			TableId(conf.FeishuTableID).
-			PageSize(500).
-			PageToken(pageToken).
-			Build()
-		resp, err := client.Client.Bitable.V1.AppTableRecord.Search(context.Background(), req)
-		if err != nil {
-			return err
-		}
+	record := larkbitable.NewAppTableRecordBuilder().Fields(fields).Build()
+	req := larkbitable.NewUpdateAppTableRecordReqBuilder().
+		AppToken(conf.FeishuTableAppToken).
+		TableId(conf.FeishuTableID).
+		RecordId(recordID).
+		AppTableRecord(record).Build()
 
-		if !resp.Success() {
-			return fmt.Errorf("get feishu record request failed: %s", resp.RawBody)
-		}
-		var instResp FeishuResponse
-		if err = json.Unmarshal(resp.RawBody, &instResp); err != nil {
-			return fmt.Errorf("解析表格中是否存在实施记录失败: %w", err)
-		}
+	resp, err := client.Client.Bitable.V1.AppTableRecord.Update(context.Background(), req)
+	if err != nil {
+		return err
+	}
 
-		pageToken = instResp.Data.PageToken
-		for _, record := range instResp.Data.Records {
-			if len(record.Fields.CompanyFullName) < 1 {
-				continue
-			}
-			m.feishuRecords[record.Fields.CompanyFullName[0].Text] = record
-		}
-		if !instResp.Data.HasMore {
-			break
-		}
+	if !resp.Success() {
+		return fmt.Errorf("error response: %s", resp.RawBody)
 	}
 	return nil
 }
 
-func (m *MaintenanceToFeishuTask) Execute() error {
+func (m *MaintenanceToFeishuTask) InitResources() error {
+	return loadFeishuRecords(m.feishuRecords)
+}
+
+func (m *MaintenanceToFeishuTask) Execute(run *TaskRun) error {
+	m.run = run
 	err := m.InitResources()
 	if err != nil {
 		return err
 	}
-	maintenances, err := m.getMaintenances()
+	now := time.Now()
+	full, err := m.needFullSync(now)
 	if err != nil {
 		return err
 	}
-	for _, maintenance := range maintenances {
-		maintenance.FitData()
-		err = m.updateOrCreateFeishuRecord(maintenance)
-		if err != nil {
-			log.Printf("Error updating feishu maintenance: %v", err)
-		}
+	m.snapshot = nil
+	if full {
+		log.Printf("Start full sync of %s maintenances", m.productName)
+		m.snapshot = make(map[string]bool)
 	}
-	m.sendMsgToWecom()
-	return nil
-}
-
-func (m *MaintenanceToFeishuTask) sendMsgToWecom() {
-	conf := config.GetConf()
-	webhookUrl := conf.WechatMessageRobotWebhook
-	now := time.Now()
-	currentTime := now.Format("2006-01-02 15:04:05")
-	reqBody := map[string]interface{}{
-		"msgtype": "text",
-		"text": map[string]interface{}{
-			"content": fmt.Sprintf(
-				"[%s] 完成 Support 门户客户记录同步", currentTime,
-			),
-		},
-	}
-	client := utils.NewClient(webhookUrl)
-	for i := 0; i < 5; i++ {
-		resp, err := client.Post("", reqBody)
-		if err == nil && resp.StatusCode == 200 {
-			break
+	if err = m.syncMaintenances(full); err != nil {
+		return err
+	}
+	if full {
+		if err = m.handleMissingCustomers(m.snapshot); err != nil {
+			log.Printf("Handle missing customers failed: %v", err)
 		}
-		if i == 4 {
-			log.Fatalf("Send message to wecom failed: %v", resp)
+	}
+	if m.run.DryRun {
+		return nil
+	}
+	if full {
+		err = syncStateBucket(m.run.Cache).Put(MaintenanceLastFullSync, now.Unix())
+		if err != nil {
+			return fmt.Errorf("save full sync time failed: %w", err)
 		}
 	}
-
+	return nil
 }
@@ -2,7 +2,7 @@ package workflow
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"strconv"
@@ -76,7 +76,9 @@ type MaintenanceRecordResponse struct {
 type MaintenanceRecordToFeishuTask struct {
 	executeTimes int
 
-	feishuRecords map[string]Record
+	feishuRecords  map[string]Record
+	companyMapping map[string]string
+	run            *TaskRun
 }
 
 func (m *MaintenanceRecordToFeishuTask) getMaxValue() (maxValue int) {
@@ -88,45 +90,116 @@ func (m *MaintenanceRecordToFeishuTask) getMaxValue() (maxValue int) {
 	return
 }
 
-func (m *MaintenanceRecordToFeishuTask) getMaintenanceRecords() ([]MaintenanceRecord, error) {
-	var maintenanceRecords []MaintenanceRecord
+func (m *MaintenanceRecordToFeishuTask) getMaintenanceRecords(client utils.SupportClient, marker int) (*MaintenanceRecordResponse, error) {
 	var maintenanceRecordResp MaintenanceRecordResponse
-	var marker int
+	maxValue := m.getMaxValue()
+	baseUrl := "/openapi/v1/bi/maintenance-records?region=northern&max=%v"
+	url := fmt.Sprintf(baseUrl, maxValue)
+	if marker != 0 {
+		url += fmt.Sprintf("&marker=%v", marker)
+	}
+	log.Printf("URL: %s", url)
+	err := client.Get(url, &maintenanceRecordResp)
+	if err != nil {
+		return nil, err
+	}
+	m.executeTimes += 1
+	log.Printf("Len: %d", len(maintenanceRecordResp.Data))
+	return &maintenanceRecordResp, nil
+}
+
+// writeMaintenanceRecords 写入一页维护记录，飞书中不存在的客户记录转入待处理队列，
+// 连续失败 maxWriteAttempts 次的记录也转入队列；只有整页都写入或转入队列后才返回 nil，调用方据此推进 marker
+func (m *MaintenanceRecordToFeishuTask) writeMaintenanceRecords(records []MaintenanceRecord) error {
+	failures, err := loadWriteFailures(m.run)
+	if err != nil {
+		return fmt.Errorf("load write failures failed: %w", err)
+	}
+	var orphans []MaintenanceRecord
+	var failed int
+	var lastErr error
+	for _, maintenanceRecord := range records {
+		id := strconv.Itoa(maintenanceRecord.ID)
+		err = m.updateDataToFeishu(maintenanceRecord)
+		if err == nil {
+			failures.succeeded(id)
+			continue
+		}
+		if errors.Is(err, errFeishuCompanyNotFound) {
+			orphans = append(orphans, maintenanceRecord)
+			continue
+		}
+		log.Printf("updating feishu maintenance record failed: %v", err)
+		if attempts, giveUp := failures.failed(id, err); giveUp {
+			reason := fmt.Sprintf("failed %d times: %v", attempts, err)
+			parked, parkErr := parkPendingRecords(m.run.Cache, []MaintenanceRecord{maintenanceRecord}, reason)
+			if parkErr == nil {
+				m.run.Stats.Parked += parked
+				continue
+			}
+			log.Printf("Park maintenance record %v failed: %v", maintenanceRecord.ID, parkErr)
+		}
+		m.run.Stats.Fail(err)
+		failed += 1
+		lastErr = err
+	}
+	if m.run.DryRun {
+		for _, orphan := range orphans {
+			m.run.Report.Add(DiffEntry{Action: DiffActionPark, Company: orphan.CompanyName, Note: orphan.String()})
+		}
+		orphans = nil
+	}
+	parked, err := parkPendingRecords(m.run.Cache, orphans, "feishu company not found")
+	if err != nil {
+		return fmt.Errorf("park pending records failed: %w", err)
+	}
+	m.run.Stats.Parked += parked
+	if failed > 0 {
+		return fmt.Errorf("%d of %d records failed, last error: %w", failed, len(records), lastErr)
+	}
+	return nil
+}
+
+func (m *MaintenanceRecordToFeishuTask) syncMaintenanceRecords() error {
 	client := utils.NewSupportClient()
-	cache := utils.GetCache()
-	err := cache.Get(MaintenanceRecordLastMarker, &marker)
+	marker, saved, err := loadCheckpoint(m.run.Cache, MaintenanceRecordLastMarker)
 	if err != nil {
+		return fmt.Errorf("load checkpoint failed: %w", err)
+	}
+	if !saved {
+		log.Printf("[%s] 没有保存的 marker，从头同步", taskTitles[m.run.Task])
+	}
+	// 旧版本在最后一页保存过 -1，从头同步一次，已写入的记录会被跳过
+	if marker < 0 {
 		marker = 0
 	}
 	for {
-		maxValue := m.getMaxValue()
-		baseUrl := "/openapi/v1/bi/maintenance-records?region=northern&max=%v"
-		url := fmt.Sprintf(baseUrl, maxValue)
-		if marker != 0 {
-			url += fmt.Sprintf("&marker=%v", marker)
-		}
-		fmt.Println("URL: ", url)
-		err = client.Get(url, &maintenanceRecordResp)
+		maintenanceRecordResp, err := m.getMaintenanceRecords(client, marker)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		m.executeTimes += 1
-		marker = maintenanceRecordResp.Marker
-		err = cache.Set(MaintenanceRecordLastMarker, marker, 0)
-		maintenanceRecords = append(maintenanceRecords, maintenanceRecordResp.Data...)
-		fmt.Println("Len: ", len(maintenanceRecords))
-		if marker == -1 {
+		if err = m.writeMaintenanceRecords(maintenanceRecordResp.Data); err != nil {
+			return fmt.Errorf("page at marker %v not committed: %w", marker, err)
+		}
+		// 最后一页不保存 -1，保留该页的起始 marker，下次从这一页重新拉取新增记录（按 ID 去重）
+		if maintenanceRecordResp.Marker == -1 {
 			break
 		}
+		marker = maintenanceRecordResp.Marker
+		if m.run.DryRun {
+			continue
+		}
+		if err = saveCheckpoint(m.run.Cache, MaintenanceRecordLastMarker, marker); err != nil {
+			return fmt.Errorf("save checkpoint failed: %w", err)
+		}
 	}
-	return maintenanceRecords, nil
+	return nil
 }
 
 func (m *MaintenanceRecordToFeishuTask) getFeishuMaintenanceRecord(companyName string) (*MaintenanceRecordTable, error) {
-	var instance Record
-	var exists bool
-	if instance, exists = m.feishuRecords[companyName]; !exists {
-		return nil, fmt.Errorf("feishu company %s not found", companyName)
+	instance, exists := m.findFeishuRecord(companyName)
+	if !exists {
+		return nil, fmt.Errorf("feishu company %s %w", companyName, errFeishuCompanyNotFound)
 	}
 
 	content := ""
@@ -140,6 +213,22 @@ func (m *MaintenanceRecordToFeishuTask) getFeishuMaintenanceRecord(companyName s
 	return maintenanceTable, nil
 }
 
+func (m *MaintenanceRecordToFeishuTask) findFeishuRecord(companyName string) (Record, bool) {
+	if instance, exists := m.feishuRecords[companyName]; exists {
+		return instance, true
+	}
+	recordID, mapped := m.companyMapping[companyName]
+	if !mapped {
+		return Record{}, false
+	}
+	for _, instance := range m.feishuRecords {
+		if instance.RecordID == recordID {
+			return instance, true
+		}
+	}
+	return Record{}, false
+}
+
 func (m *MaintenanceRecordToFeishuTask) updateDataToFeishu(mr MaintenanceRecord) error {
 	conf := config.GetConf()
 	client := utils.NewFeishuClient()
@@ -149,25 +238,28 @@ func (m *MaintenanceRecordToFeishuTask) updateDataToFeishu(mr MaintenanceRecord)
 	}
 	var newRecords []string
 	var recordSet = make(map[int]bool)
-	if feishuRecord.Content == "" {
-		newRecords = append(newRecords, mr.String())
-	} else {
-		if mr.CompanyName == "宁夏医科大学" {
-			fmt.Println(mr)
-		}
-		for _, item := range strings.Split(feishuRecord.Content, SplitFlag) {
-			itemIDString := strings.Split(item, "-")[0]
-			itemID, err := strconv.Atoi(itemIDString)
-			if err != nil {
-				continue
-			}
-			newRecords = append(newRecords, item)
-			recordSet[itemID] = true
-			if exists, _ := recordSet[mr.ID]; !exists {
-				newRecords = append(newRecords, mr.String())
-				recordSet[mr.ID] = true
-			}
+	for _, item := range strings.Split(feishuRecord.Content, SplitFlag) {
+		itemIDString := strings.Split(item, "-")[0]
+		itemID, err := strconv.Atoi(itemIDString)
+		if err != nil {
+			continue
 		}
+		newRecords = append(newRecords, item)
+		recordSet[itemID] = true
+	}
+	// 断点续传时会重复拉取已写入的页，已存在的记录不再重复写入
+	if recordSet[mr.ID] {
+		return nil
+	}
+	newRecords = append(newRecords, mr.String())
+	content := strings.Join(newRecords, SplitFlag)
+	if m.run.DryRun {
+		m.refreshFeishuRecord(feishuRecord.RecordID, content)
+		m.run.Report.Add(DiffEntry{
+			Action: DiffActionAppend, Company: mr.CompanyName, RecordID: feishuRecord.RecordID,
+			Changes: ChangeSet{{Field: `维护记录`, New: mr.String()}},
+		})
+		return nil
 	}
 
 	req := larkbitable.NewUpdateAppTableRecordReqBuilder().
@@ -176,7 +268,7 @@ func (m *MaintenanceRecordToFeishuTask) updateDataToFeishu(mr MaintenanceRecord)
 		RecordId(feishuRecord.RecordID).
 		AppTableRecord(larkbitable.NewAppTableRecordBuilder().
 			Fields(map[string]interface{}{
-				`维护记录`: strings.Join(newRecords, SplitFlag),
+				`维护记录`: content,
 			}).Build()).
 		Build()
 
@@ -188,63 +280,48 @@ func (m *MaintenanceRecordToFeishuTask) updateDataToFeishu(mr MaintenanceRecord)
 	if !resp.Success() {
 		return fmt.Errorf("error response: %s", larkcore.Prettify(resp.CodeError))
 	}
+	// 同一客户可能在一次同步中有多条记录，刷新本地快照避免后写覆盖先写
+	m.refreshFeishuRecord(feishuRecord.RecordID, content)
 	log.Printf("Update maintenance record %v success", mr.CompanyName)
+	m.run.Stats.Appended += 1
+	recordChange(m.run.Cache, ChangeLogEntry{
+		RecordID: feishuRecord.RecordID, Company: mr.CompanyName, Action: ChangeActionUpdate,
+		Changes: ChangeSet{{Field: `维护记录`, Old: feishuRecord.Content, New: content}},
+		Task:    m.run.Task, RunID: m.run.ID, Source: ChangeSourceSupport,
+	})
 	return nil
 }
 
-func (m *MaintenanceRecordToFeishuTask) InitResources() error {
-	// 飞书表格一次性获取，API 有限额
-	pageToken := ""
-	conf := config.GetConf()
-	client := utils.NewFeishuClient()
-	for {
-		req := larkbitable.NewSearchAppTableRecordReqBuilder().
-			AppToken(conf.FeishuTableAppToken).
-			TableId(conf.FeishuTableID).
-			PageSize(500).
-			PageToken(pageToken).
-			Build()
-		resp, err := client.Client.Bitable.V1.AppTableRecord.Search(context.Background(), req)
-		if err != nil {
-			return err
-		}
-
-		if !resp.Success() {
-			return fmt.Errorf("get feishu record request failed: %s", resp.RawBody)
-		}
-		var instResp FeishuResponse
-		if err = json.Unmarshal(resp.RawBody, &instResp); err != nil {
-			return fmt.Errorf("解析表格中是否存在实施记录失败: %w", err)
-		}
-
-		pageToken = instResp.Data.PageToken
-		for _, record := range instResp.Data.Records {
-			if len(record.Fields.CompanyFullName) < 1 {
-				continue
-			}
-			m.feishuRecords[record.Fields.CompanyFullName[0].Text] = record
-		}
-		if !instResp.Data.HasMore {
-			break
+func (m *MaintenanceRecordToFeishuTask) refreshFeishuRecord(recordID, content string) {
+	for name, instance := range m.feishuRecords {
+		if instance.RecordID != recordID {
+			continue
 		}
+		instance.Fields.MaintenanceRecords = []TypeTextField{{Type: "text", Text: content}}
+		m.feishuRecords[name] = instance
 	}
-	return nil
 }
 
-func (m *MaintenanceRecordToFeishuTask) Execute() error {
-	err := m.InitResources()
+func (m *MaintenanceRecordToFeishuTask) InitResources() error {
+	if err := loadFeishuRecords(m.feishuRecords); err != nil {
+		return err
+	}
+	mapping, err := loadCompanyMapping(m.run.Cache)
 	if err != nil {
 		return err
 	}
-	maintenanceRecords, err := m.getMaintenanceRecords()
+	m.companyMapping = mapping
+	return nil
+}
+
+func (m *MaintenanceRecordToFeishuTask) Execute(run *TaskRun) error {
+	m.run = run
+	err := m.InitResources()
 	if err != nil {
 		return err
 	}
-	for _, maintenanceRecord := range maintenanceRecords {
-		err = m.updateDataToFeishu(maintenanceRecord)
-		if err != nil {
-			log.Printf("updating feishu maintenance record failed: %v", err)
-		}
+	if err = m.retryPendingRecords(); err != nil {
+		log.Printf("retry pending maintenance records failed: %v", err)
 	}
-	return nil
+	return m.syncMaintenanceRecords()
 }
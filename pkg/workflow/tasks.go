@@ -1,52 +1,282 @@
 package workflow
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
 	"log"
+	"net/http"
 	"sync"
 	"time"
+
+	"support-workflow/pkg/config"
+	"support-workflow/pkg/utils"
+
+	"github.com/gin-gonic/gin"
 )
 
 type Task interface {
-	Execute() error
+	Execute(run *TaskRun) error
+}
+
+const (
+	MaintenanceTaskName       = "maintenances"
+	MaintenanceRecordTaskName = "maintenance-records"
+	ReminderTaskName          = "subscription-reminders"
+)
+
+var (
+	TaskNames  = []string{MaintenanceTaskName, MaintenanceRecordTaskName, ReminderTaskName}
+	taskTitles = map[string]string{
+		MaintenanceTaskName:       "企业基本数据回传飞书",
+		MaintenanceRecordTaskName: "维护记录数据回传飞书",
+		ReminderTaskName:          "订阅及维保到期提醒",
+	}
+	taskIntervals = map[string]time.Duration{
+		ReminderTaskName: 1 * time.Hour,
+	}
+)
+
+func NewTask(name string) (Task, error) {
+	switch name {
+	case MaintenanceTaskName:
+		return &MaintenanceToFeishuTask{
+			productName: "JumpServer", maxValue: 1000,
+			feishuRecords: make(map[string]Record),
+		}, nil
+	case MaintenanceRecordTaskName:
+		return &MaintenanceRecordToFeishuTask{
+			feishuRecords: make(map[string]Record),
+		}, nil
+	case ReminderTaskName:
+		return &SubscriptionReminderTask{
+			feishuRecords: make(map[string]Record),
+		}, nil
+	}
+	return nil, fmt.Errorf("task %s not found", name)
+}
+
+type TaskOptions struct {
+	// DryRun 只计算将要发生的写入并生成报告，不调用飞书写接口，也不推进本地状态
+	DryRun   bool
+	FullSync bool
+}
+
+// TaskRun 一次任务执行的上下文，用于关联这次执行产生的变更记录
+type TaskRun struct {
+	ID        string
+	Task      string
+	StartedAt time.Time
+	TaskOptions
+	Report *DiffReport
+	Stats  RunStats
+	// Cache、Outbox 由 TaskManager 注入，任务通过它们读写本地状态和发送通知
+	Cache  *utils.Cache
+	Outbox *utils.Outbox
+}
+
+func newRunID() string {
+	buf := make([]byte, 4)
+	_, _ = rand.Read(buf)
+	return fmt.Sprintf("%s-%s", time.Now().Format("20060102150405"), hex.EncodeToString(buf))
+}
+
+func NewTaskRun(task string, options TaskOptions) *TaskRun {
+	run := &TaskRun{ID: newRunID(), Task: task, StartedAt: time.Now(), TaskOptions: options}
+	if options.DryRun {
+		run.Report = NewDiffReport(run)
+	}
+	return run
 }
 
 type TaskManager struct {
+	// Cache 进程共享的缓存，Outbox 基于同一缓存的发件箱，任务和 HTTP 处理函数都通过 TaskManager 使用
+	Cache  *utils.Cache
+	Outbox *utils.Outbox
+	// Sweeper 后台清理缓存中的过期键
+	Sweeper *utils.Sweeper
 	tickers []*time.Ticker
 	mu      sync.Mutex
 	running map[string]bool
+	// stopped 停止后不再开始新的执行，inflight 用于等待执行中的任务结束后再关闭缓存
+	stopped  bool
+	inflight sync.WaitGroup
+	// reschedule 通知定时任务使用新的执行间隔
+	reschedule map[string]chan time.Duration
+	// Options 定时任务使用的执行选项
+	Options TaskOptions
 }
 
-func (tm *TaskManager) StartTasks() {
-	task1 := &MaintenanceToFeishuTask{
-		productName: "JumpServer", maxValue: 1000,
-		feishuRecords: make(map[string]Record),
+func NewTaskManager(cache *utils.Cache, outbox *utils.Outbox, options TaskOptions) *TaskManager {
+	return &TaskManager{Cache: cache, Outbox: outbox, Sweeper: utils.NewSweeper(cache), Options: options}
+}
+
+// taskIntervalOf TASK_INTERVALS 中配置的间隔优先，其次是内置间隔
+func taskIntervalOf(conf config.Config, name string) time.Duration {
+	if value, exists := conf.TaskIntervals[name]; exists {
+		if interval, err := time.ParseDuration(value); err == nil && interval > 0 {
+			return interval
+		}
+	}
+	if interval, exists := taskIntervals[name]; exists {
+		return interval
 	}
-	task2 := &MaintenanceRecordToFeishuTask{
-		feishuRecords: make(map[string]Record),
+	return 1 * time.Minute
+}
+
+func taskInterval(name string) time.Duration {
+	return taskIntervalOf(config.GetConf(), name)
+}
+
+func (tm *TaskManager) StartTasks() {
+	for _, name := range TaskNames {
+		task, err := NewTask(name)
+		if err != nil {
+			log.Printf("[%s] 任务创建失败: %v", name, err)
+			continue
+		}
+		tm.startCronJob(name, task)
 	}
-	tm.startCronJob("企业基本数据回传飞书", task1)
-	tm.startCronJob("维护记录数据回传飞书", task2)
+	tm.watchTaskHealth()
 }
 
 func (tm *TaskManager) startCronJob(name string, task Task) {
+	interval := taskInterval(name)
+	reschedule := make(chan time.Duration, 1)
+	tm.mu.Lock()
+	if tm.reschedule == nil {
+		tm.reschedule = make(map[string]chan time.Duration)
+	}
+	tm.reschedule[name] = reschedule
+	tm.mu.Unlock()
 	go func() {
 		timer := time.NewTimer(0)
 		for {
-			<-timer.C
+			select {
+			case interval = <-reschedule:
+				// 新的间隔从现在开始计算，执行中收到的变更在本次执行结束后生效
+				log.Printf("[%s] 执行间隔调整为 %s", taskTitles[name], interval)
+				timer.Reset(interval)
+				continue
+			case <-timer.C:
+			}
 
-			log.Printf("开始执行任务: %v", name)
-			if err := task.Execute(); err != nil {
-				log.Printf("[%s] 任务执行失败: %v", name, err)
+			if _, err := tm.Run(name, task, tm.Options); err != nil {
+				log.Printf("[%s] 任务执行失败: %v", taskTitles[name], err)
 			}
 
-			timer.Reset(1 * time.Minute)
+			timer.Reset(interval)
 		}
 	}()
 }
 
+// Reschedule 配置变更回调，执行间隔变化的定时任务重新计时
+func (tm *TaskManager) Reschedule(old, new config.Config) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	for name, reschedule := range tm.reschedule {
+		interval := taskIntervalOf(new, name)
+		if interval == taskIntervalOf(old, name) {
+			continue
+		}
+		// 只保留最新的一次变更
+		select {
+		case <-reschedule:
+		default:
+		}
+		reschedule <- interval
+	}
+}
+
+// Run 执行一次任务，同名任务同一时间只允许一个在运行
+func (tm *TaskManager) Run(name string, task Task, options TaskOptions) (*TaskRun, error) {
+	tm.mu.Lock()
+	if tm.stopped {
+		tm.mu.Unlock()
+		return nil, fmt.Errorf("task manager is stopped")
+	}
+	if tm.running == nil {
+		tm.running = make(map[string]bool)
+	}
+	if tm.running[name] {
+		tm.mu.Unlock()
+		return nil, fmt.Errorf("task %s is already running", name)
+	}
+	tm.running[name] = true
+	tm.inflight.Add(1)
+	tm.mu.Unlock()
+	defer func() {
+		tm.mu.Lock()
+		delete(tm.running, name)
+		tm.mu.Unlock()
+		tm.inflight.Done()
+	}()
+
+	run := NewTaskRun(name, options)
+	run.Cache, run.Outbox = tm.Cache, tm.Outbox
+	log.Printf("开始执行任务: %v, run: %s", taskTitles[name], run.ID)
+	err := task.Execute(run)
+	if run.DryRun {
+		log.Print(run.Report.Text())
+	}
+	tm.summarize(run, err)
+	tm.recordHealth(run, err)
+	return run, err
+}
+
+// RunOnce 按名称新建任务实例并执行一次，用于手动触发
+func (tm *TaskManager) RunOnce(name string, options TaskOptions) (*TaskRun, error) {
+	task, err := NewTask(name)
+	if err != nil {
+		return nil, err
+	}
+	return tm.Run(name, task, options)
+}
+
+func (tm *TaskManager) triggerTask(c *gin.Context) {
+	options := TaskOptions{
+		DryRun:   c.Query("dry_run") == "true",
+		FullSync: c.Query("full") == "true",
+	}
+	run, err := tm.RunOnce(c.Param("name"), options)
+	if run == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if run.DryRun && c.Query("format") == "text" {
+		c.String(http.StatusOK, run.Report.Text())
+		return
+	}
+	response := gin.H{"runId": run.ID, "task": run.Task, "dryRun": run.DryRun}
+	if run.Report != nil {
+		response["report"] = run.Report
+	}
+	if err != nil {
+		response["error"] = err.Error()
+		c.JSON(http.StatusInternalServerError, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// Stop 停止定时任务并等待执行中的任务结束，超时后不再等待
 func (tm *TaskManager) Stop() {
 	log.Println("正在停止所有定时任务...")
+	tm.mu.Lock()
+	tm.stopped = true
+	tm.mu.Unlock()
 	for _, ticker := range tm.tickers {
 		ticker.Stop()
 	}
+
+	done := make(chan struct{})
+	go func() {
+		tm.inflight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(30 * time.Second):
+		log.Println("等待执行中的任务超时")
+	}
 }
@@ -1,27 +1,26 @@
 package workflow
 
 import (
+	"encoding/json"
 	"errors"
 	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"support-workflow/pkg/config"
 )
 
-var (
-	configPath = ""
-)
-
-func RunForever() {
-	flag.StringVar(&configPath, "f", "config.yml", "config.yml path")
-
-	config.Setup(configPath)
-	httpServer := NewHttpServer()
-	taskManager := &TaskManager{}
+// Serve 启动 HTTP 服务、定时任务和发件箱，收到终止信号后优雅关闭，调用前需要先 config.Setup；
+// 返回时任务已全部结束，缓存由调用方关闭
+func Serve(taskManager *TaskManager) {
+	taskManager.Outbox.Start()
+	taskManager.Sweeper.Start()
+	httpServer := NewHttpServer(taskManager)
 
 	go func() {
 		if err := httpServer.Start(); err != nil && !errors.Is(err, http.ErrServerClosed) {
@@ -31,13 +30,100 @@ func RunForever() {
 
 	go taskManager.StartTasks()
 
+	config.OnChange(taskManager.Reschedule)
+	config.OnChange(httpServer.Reconfigure)
+	config.Watch()
+
 	gracefulStop := make(chan os.Signal, 1)
 	signal.Notify(gracefulStop, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT)
 	<-gracefulStop
 	log.Println("接收到终止信号，开始优雅关闭...")
 
-	taskManager.Stop()
 	httpServer.Stop()
+	taskManager.Stop()
+	taskManager.Outbox.Stop()
+	taskManager.Sweeper.Stop()
 
 	log.Println("所有服务已优雅关闭")
 }
+
+func RunTaskOnce(taskManager *TaskManager, name string) error {
+	run, err := taskManager.RunOnce(name, taskManager.Options)
+	// 单次执行不启动后台投递，退出前把本次产生的通知发出去
+	taskManager.Outbox.Deliver()
+	// 文本报告已写入日志（stderr），stdout 输出 JSON 便于管道处理
+	if run != nil && run.DryRun {
+		data, _ := json.MarshalIndent(run.Report, "", "  ")
+		fmt.Println(string(data))
+	}
+	if err != nil {
+		return fmt.Errorf("[%s] 任务执行失败: %w", name, err)
+	}
+	return nil
+}
+
+// CheckConfig 校验配置并可选地检查外部地址的连通性，返回进程退出码
+func CheckConfig(options config.LoadOptions, args []string) int {
+	fs := flag.NewFlagSet("config check", flag.ExitOnError)
+	probe := fs.Bool("probe", false, "dial configured endpoints over TCP without sending requests")
+	timeout := fs.Duration("timeout", 3*time.Second, "probe timeout")
+	standIns := make(config.StandIns)
+	fs.Var(standIns, "stand-in", "probe KEY=ADDRESS instead of the configured address, repeatable")
+	_ = fs.Parse(args)
+
+	conf, err := config.Load(options)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	// 校验和连通性检查的错误中可能带有敏感值，输出前掩盖
+	out := config.NewRedactingWriter(os.Stdout)
+	out.SetSecrets(conf.SecretValues())
+	code := 0
+	if err = conf.Validate(); err != nil {
+		fmt.Fprintln(out, err)
+		code = 1
+	} else {
+		fmt.Fprintln(out, "config ok")
+	}
+	if !*probe {
+		return code
+	}
+	for _, result := range conf.Probe(standIns, *timeout) {
+		target := result.Address
+		if result.StandIn {
+			target += " (stand-in)"
+		}
+		if result.Err != nil {
+			fmt.Fprintf(out, "[FAIL] %s %s: %v\n", result.Key, target, result.Err)
+			code = 1
+			continue
+		}
+		fmt.Fprintf(out, "[ OK ] %s %s\n", result.Key, target)
+	}
+	return code
+}
+
+// SealSecrets 用 SW_SECRETS_KEY 加密 JSON 格式的密钥文件，供 SECRETS_FILE 使用
+func SealSecrets(args []string) int {
+	if len(args) != 2 {
+		fmt.Println("usage: config seal <secrets.json> <secrets.enc>")
+		return 2
+	}
+	plaintext, err := os.ReadFile(args[0])
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	sealed, err := config.SealSecrets(plaintext)
+	if err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	if err = os.WriteFile(args[1], sealed, 0600); err != nil {
+		fmt.Println(err)
+		return 1
+	}
+	fmt.Printf("sealed %s to %s\n", args[0], args[1])
+	return 0
+}
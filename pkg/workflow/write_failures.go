@@ -0,0 +1,86 @@
+package workflow
+
+import (
+	"log"
+	"time"
+
+	"support-workflow/pkg/utils"
+)
+
+const (
+	// WriteFailureNamespace 同步中写入失败的条目，键为 任务名:条目 ID
+	WriteFailureNamespace = "write-failures"
+
+	// maxWriteAttempts 同一条目连续失败达到该次数后转入待处理，不再阻塞 marker 和全量同步
+	maxWriteAttempts = 5
+)
+
+type WriteFailure struct {
+	Attempts  int    `json:"attempts"`
+	Reason    string `json:"reason"`
+	CreatedAt int64  `json:"createdAt"`
+	UpdatedAt int64  `json:"updatedAt"`
+}
+
+func writeFailureBucket(cache *utils.Cache) *utils.TypedBucket[WriteFailure] {
+	return utils.NewTypedBucket[WriteFailure](cache, WriteFailureNamespace)
+}
+
+// writeFailures 一次执行中某个任务的写入失败计数，页面每次重新拉取时累加，成功后清除
+type writeFailures struct {
+	bucket   *utils.TypedBucket[WriteFailure]
+	task     string
+	dryRun   bool
+	failures map[string]WriteFailure
+}
+
+func loadWriteFailures(run *TaskRun) (*writeFailures, error) {
+	bucket := writeFailureBucket(run.Cache)
+	entries, err := bucket.Scan(run.Task + ":")
+	if err != nil {
+		return nil, err
+	}
+	failures := make(map[string]WriteFailure, len(entries))
+	for _, entry := range entries {
+		failures[entry.Key] = entry.Value
+	}
+	return &writeFailures{bucket: bucket, task: run.Task, dryRun: run.DryRun, failures: failures}, nil
+}
+
+func (w *writeFailures) key(id string) string {
+	return w.task + ":" + id
+}
+
+// succeeded 清除条目之前的失败计数，返回之前是否失败过
+func (w *writeFailures) succeeded(id string) bool {
+	key := w.key(id)
+	if _, exists := w.failures[key]; !exists || w.dryRun {
+		return false
+	}
+	delete(w.failures, key)
+	if err := w.bucket.Delete(key); err != nil {
+		log.Printf("Clear write failure of %s failed: %v", key, err)
+	}
+	return true
+}
+
+// failed 累加失败次数，达到 maxWriteAttempts 时返回 true，调用方转入待处理后继续推进；dry-run 不计数
+func (w *writeFailures) failed(id string, err error) (int, bool) {
+	if w.dryRun {
+		return 0, false
+	}
+	key := w.key(id)
+	now := time.Now().Unix()
+	failure, exists := w.failures[key]
+	if !exists {
+		failure.CreatedAt = now
+	}
+	failure.Attempts += 1
+	failure.Reason = err.Error()
+	failure.UpdatedAt = now
+	w.failures[key] = failure
+	if putErr := w.bucket.Put(key, failure); putErr != nil {
+		log.Printf("Save write failure of %s failed: %v", key, putErr)
+	}
+	return failure.Attempts, failure.Attempts >= maxWriteAttempts
+}